@@ -0,0 +1,400 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+type BackupMode string
+
+const (
+	BackupNone   BackupMode = "none"
+	BackupMirror BackupMode = "mirror"
+	BackupFolder BackupMode = "folder"
+	BackupTar    BackupMode = "tar"
+	BackupZip    BackupMode = "zip"
+)
+
+const (
+	backupFolderName    = ".avifconv-backup"
+	backupArchivePrefix = "avifconv-backup-"
+	backupSetLayout     = "20060102-150405"
+)
+
+func parseBackupMode(s string) (BackupMode, error) {
+	switch m := BackupMode(strings.ToLower(s)); m {
+	case BackupNone, BackupMirror, BackupFolder, BackupTar, BackupZip:
+		return m, nil
+	case "":
+		return BackupNone, nil
+	}
+	return "", fmt.Errorf("error: unknown backup mode %q (none, mirror, folder, tar, zip)", s)
+}
+
+// Backup moves originals aside instead of deleting them. Each run writes one
+// backup set named after its start time, so sets can be pruned by age.
+//
+// Archive modes stage originals in a folder next to the archive and pack
+// them when the run ends, so every original is durable on disk before it
+// leaves its place. A run that dies early leaves the staging folder, which
+// undo restores from.
+type Backup struct {
+	Mode    BackupMode
+	Root    string
+	Dir     string
+	SetName string
+
+	mu     sync.Mutex
+	staged []string
+}
+
+// BackupLocation records where an original was stored. Entry is set when Path
+// is an archive.
+type BackupLocation struct {
+	Path  string `json:"path"`
+	Entry string `json:"entry,omitempty"`
+}
+
+const backupStagingSuffix = ".staging"
+
+func NewBackup(mode BackupMode, root, dir, setName string) *Backup {
+	if mode == BackupNone {
+		return nil
+	}
+	if dir == "" {
+		dir = filepath.Join(root, backupFolderName)
+	}
+	if abs, err := filepath.Abs(dir); err == nil {
+		dir = abs
+	}
+
+	return &Backup{
+		Mode:    mode,
+		Root:    root,
+		Dir:     dir,
+		SetName: setName,
+	}
+}
+
+// Store moves the file at path into the backup set and returns where it went.
+func (b *Backup) Store(path string) (*BackupLocation, error) {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+
+	rel, err := filepath.Rel(b.Root, path)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		rel = filepath.Base(path)
+	}
+
+	switch b.Mode {
+	case BackupMirror:
+		dst := filepath.Join(b.Dir, b.SetName, rel)
+		return &BackupLocation{Path: dst}, moveFile(path, dst)
+	case BackupFolder:
+		dst := filepath.Join(filepath.Dir(path), backupFolderName, b.SetName, filepath.Base(path))
+		return &BackupLocation{Path: dst}, moveFile(path, dst)
+	case BackupTar, BackupZip:
+		entry := filepath.ToSlash(rel)
+		if err := moveFile(path, filepath.Join(b.stagingDir(), rel)); err != nil {
+			return nil, err
+		}
+
+		b.mu.Lock()
+		b.staged = append(b.staged, entry)
+		b.mu.Unlock()
+
+		return &BackupLocation{Path: b.archivePath(), Entry: entry}, nil
+	}
+
+	return nil, fmt.Errorf("unsupported backup mode %q", b.Mode)
+}
+
+func (b *Backup) archivePath() string {
+	return filepath.Join(b.Dir, backupArchivePrefix+b.SetName+"."+string(b.Mode))
+}
+
+func (b *Backup) stagingDir() string {
+	return b.archivePath() + backupStagingSuffix
+}
+
+// Close packs the staged originals into the archive. The staging folder is
+// only removed once the archive is synced and in place.
+func (b *Backup) Close() error {
+	if b == nil {
+		return nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if len(b.staged) == 0 {
+		return nil
+	}
+
+	if err := b.writeArchive(); err != nil {
+		return err
+	}
+	b.staged = nil
+
+	if err := os.RemoveAll(b.stagingDir()); err != nil {
+		return fmt.Errorf("error removing backup staging folder: %w", err)
+	}
+	return nil
+}
+
+func (b *Backup) writeArchive() (err error) {
+	f, err := os.CreateTemp(b.Dir, tempFilePrefix+"*."+string(b.Mode))
+	if err != nil {
+		return fmt.Errorf("error creating backup archive: %w", err)
+	}
+	tempPath := f.Name()
+	defer func() {
+		if err != nil {
+			f.Close()
+			os.Remove(tempPath)
+		}
+	}()
+
+	var tw *tar.Writer
+	var zw *zip.Writer
+	if b.Mode == BackupTar {
+		tw = tar.NewWriter(f)
+	} else {
+		zw = zip.NewWriter(f)
+	}
+
+	for _, entry := range b.staged {
+		if err := addToArchive(tw, zw, filepath.Join(b.stagingDir(), filepath.FromSlash(entry)), entry); err != nil {
+			return err
+		}
+	}
+
+	if tw != nil {
+		err = tw.Close()
+	} else {
+		err = zw.Close()
+	}
+	if err != nil {
+		return fmt.Errorf("error finishing backup archive: %w", err)
+	}
+	if err = f.Sync(); err != nil {
+		return fmt.Errorf("error syncing backup archive: %w", err)
+	}
+	if err = f.Close(); err != nil {
+		return fmt.Errorf("error closing backup archive: %w", err)
+	}
+	if err = os.Chmod(tempPath, 0o644); err != nil {
+		return fmt.Errorf("error setting backup archive mode: %w", err)
+	}
+	if err = os.Rename(tempPath, b.archivePath()); err != nil {
+		return fmt.Errorf("error moving backup archive into place: %w", err)
+	}
+	if err = syncDir(b.Dir); err != nil {
+		return fmt.Errorf("error syncing backup directory: %w", err)
+	}
+	return nil
+}
+
+// addToArchive writes the file at path to whichever of tw and zw is set.
+func addToArchive(tw *tar.Writer, zw *zip.Writer, path, name string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("error opening original for backup: %w", err)
+	}
+	defer src.Close()
+
+	info, err := src.Stat()
+	if err != nil {
+		return fmt.Errorf("error reading original for backup: %w", err)
+	}
+
+	var w io.Writer
+	if tw != nil {
+		hdr, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return fmt.Errorf("error building tar header: %w", err)
+		}
+		hdr.Name = name
+		if err := tw.WriteHeader(hdr); err != nil {
+			return fmt.Errorf("error writing tar header: %w", err)
+		}
+		w = tw
+	} else {
+		hdr, err := zip.FileInfoHeader(info)
+		if err != nil {
+			return fmt.Errorf("error building zip header: %w", err)
+		}
+		hdr.Name = name
+		hdr.Method = zip.Store
+		w, err = zw.CreateHeader(hdr)
+		if err != nil {
+			return fmt.Errorf("error writing zip header: %w", err)
+		}
+	}
+
+	if _, err := io.Copy(w, src); err != nil {
+		return fmt.Errorf("error writing original to archive: %w", err)
+	}
+
+	return nil
+}
+
+// Prune removes all but the newest keep backup sets reachable from this backup.
+func (b *Backup) Prune(keep int) (int, error) {
+	if b.Mode == BackupFolder {
+		return pruneBackups(b.Root, keep)
+	}
+	return pruneBackupSets(b.Dir, keep)
+}
+
+// pruneBackups prunes backup sets in every .avifconv-backup folder below root.
+func pruneBackups(root string, keep int) (int, error) {
+	removed := 0
+	err := filepath.WalkDir(root, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() || d.Name() != backupFolderName {
+			return nil
+		}
+
+		n, err := pruneBackupSets(path, keep)
+		removed += n
+		if err != nil {
+			return err
+		}
+		return filepath.SkipDir
+	})
+
+	return removed, err
+}
+
+func pruneBackupSets(dir string, keep int) (int, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("error reading backup directory: %w", err)
+	}
+
+	type backupSet struct {
+		name string
+		time time.Time
+	}
+
+	var sets []backupSet
+	for _, e := range entries {
+		if t, ok := parseBackupSetName(e.Name()); ok {
+			sets = append(sets, backupSet{name: e.Name(), time: t})
+		}
+	}
+
+	if len(sets) <= keep {
+		return 0, nil
+	}
+
+	sort.Slice(sets, func(i, j int) bool {
+		return sets[i].time.After(sets[j].time)
+	})
+
+	removed := 0
+	for _, s := range sets[keep:] {
+		if err := os.RemoveAll(filepath.Join(dir, s.name)); err != nil {
+			return removed, fmt.Errorf("error removing backup set %s: %w", s.name, err)
+		}
+		removed++
+	}
+
+	if remaining, err := os.ReadDir(dir); err == nil && len(remaining) == 0 && filepath.Base(dir) == backupFolderName {
+		os.Remove(dir)
+	}
+
+	return removed, nil
+}
+
+func parseBackupSetName(name string) (time.Time, bool) {
+	name = strings.TrimPrefix(name, backupArchivePrefix)
+	name = strings.TrimSuffix(strings.TrimSuffix(name, ".tar"), ".zip")
+
+	t, err := time.Parse(backupSetLayout, name)
+	return t, err == nil
+}
+
+// moveFile renames src to dst, falling back to copy and delete when they are
+// on different filesystems.
+func moveFile(src, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return fmt.Errorf("error creating backup directory: %w", err)
+	}
+
+	err := os.Rename(src, dst)
+	copied := errors.Is(err, syscall.EXDEV)
+	if err != nil && !copied {
+		return fmt.Errorf("error moving original to backup: %w", err)
+	}
+	if copied {
+		if err := copyFile(src, dst); err != nil {
+			return err
+		}
+	}
+
+	// The new directory entry must be durable before the source is gone.
+	if err := syncDir(filepath.Dir(dst)); err != nil {
+		return fmt.Errorf("error syncing backup directory: %w", err)
+	}
+
+	if copied {
+		if err := os.Remove(src); err != nil {
+			return fmt.Errorf("error deleting original after backup copy: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("error opening %s: %w", src, err)
+	}
+	defer in.Close()
+
+	info, err := in.Stat()
+	if err != nil {
+		return fmt.Errorf("error reading %s: %w", src, err)
+	}
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return fmt.Errorf("error creating %s: %w", dst, err)
+	}
+
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return fmt.Errorf("error copying to %s: %w", dst, err)
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return fmt.Errorf("error syncing %s: %w", dst, err)
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(dst)
+		return fmt.Errorf("error closing %s: %w", dst, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const cacheFileName = ".avifconv-cache.json"
+
+type cacheEntry struct {
+	SourceHash string    `json:"source_sha256"`
+	SourceSize int64     `json:"source_size"`
+	SourceTime time.Time `json:"source_mtime"`
+	Options    string    `json:"options"`
+	Output     string    `json:"output"`
+	OutputSize int64     `json:"output_size"`
+}
+
+// Cache remembers which sources were encoded with which options so that
+// incremental runs can skip unchanged files. Entries are keyed by the source
+// path relative to Root.
+type Cache struct {
+	Path    string
+	Root    string
+	Options string
+
+	mu      sync.Mutex
+	entries map[string]cacheEntry
+}
+
+func NewCache(path, root, options string) *Cache {
+	return &Cache{
+		Path:    path,
+		Root:    root,
+		Options: options,
+		entries: make(map[string]cacheEntry),
+	}
+}
+
+func (c *Cache) Load() error {
+	data, err := os.ReadFile(c.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error reading cache: %w", err)
+	}
+
+	entries := make(map[string]cacheEntry)
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return fmt.Errorf("error parsing cache: %w", err)
+	}
+
+	c.mu.Lock()
+	c.entries = entries
+	c.mu.Unlock()
+
+	return nil
+}
+
+func (c *Cache) Save() error {
+	c.mu.Lock()
+	data, err := json.MarshalIndent(c.entries, "", "  ")
+	c.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("error encoding cache: %w", err)
+	}
+
+	if err := writeFileAtomic(c.Path, data, 0o644); err != nil {
+		return fmt.Errorf("error writing cache: %w", err)
+	}
+	return nil
+}
+
+// UpToDate reports whether source was already encoded to output with the
+// current options. The source is only hashed when its size or mtime changed.
+func (c *Cache) UpToDate(source, output string) bool {
+	key := c.key(source)
+
+	c.mu.Lock()
+	entry, ok := c.entries[key]
+	c.mu.Unlock()
+
+	if !ok || entry.Options != c.Options || entry.Output != output {
+		return false
+	}
+
+	outInfo, err := os.Stat(output)
+	if err != nil || outInfo.Size() != entry.OutputSize {
+		return false
+	}
+
+	srcInfo, err := os.Stat(source)
+	if err != nil {
+		return false
+	}
+	if srcInfo.Size() == entry.SourceSize && srcInfo.ModTime().Equal(entry.SourceTime) {
+		return true
+	}
+
+	hash, err := hashFile(source)
+	if err != nil || hash != entry.SourceHash {
+		return false
+	}
+
+	entry.SourceSize = srcInfo.Size()
+	entry.SourceTime = srcInfo.ModTime()
+	c.mu.Lock()
+	c.entries[key] = entry
+	c.mu.Unlock()
+
+	return true
+}
+
+func (c *Cache) Update(source, sourceHash string, sourceInfo os.FileInfo, output string, outputSize int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries[c.key(source)] = cacheEntry{
+		SourceHash: sourceHash,
+		SourceSize: sourceInfo.Size(),
+		SourceTime: sourceInfo.ModTime(),
+		Options:    c.Options,
+		Output:     output,
+		OutputSize: outputSize,
+	}
+}
+
+// PruneOrphans removes outputs whose source no longer exists, along with
+// their cache entries, and returns how many were removed.
+func (c *Cache) PruneOrphans() (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	removed := 0
+	for key, entry := range c.entries {
+		if _, err := os.Stat(filepath.Join(c.Root, filepath.FromSlash(key))); !errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+
+		if err := os.Remove(entry.Output); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return removed, fmt.Errorf("error removing orphaned output %s: %w", entry.Output, err)
+		}
+		delete(c.entries, key)
+		removed++
+	}
+
+	return removed, nil
+}
+
+func (c *Cache) key(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	if rel, err := filepath.Rel(c.Root, path); err == nil {
+		return filepath.ToSlash(rel)
+	}
+	return filepath.ToSlash(path)
+}
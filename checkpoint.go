@@ -0,0 +1,292 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	tempFilePrefix     = ".avifconv-tmp-"
+	checkpointInterval = time.Second
+)
+
+type checkpointState struct {
+	Root    string          `json:"root"`
+	Updated time.Time       `json:"updated"`
+	Done    map[string]bool `json:"done"`
+}
+
+// checkpointEntry is one line of the checkpoint log.
+type checkpointEntry struct {
+	Path string `json:"path"`
+	OK   bool   `json:"ok"`
+}
+
+// Checkpoint tracks which sources of a batch have been handled so an
+// interrupted run can be resumed. Done maps a path relative to Root to whether
+// its conversion succeeded.
+//
+// Outcomes are appended to a log next to the checkpoint and synced at most
+// every checkpointInterval, so the cost per file stays constant. Flush and
+// Remove compact the log into the checkpoint.
+type Checkpoint struct {
+	Path string
+
+	mu       sync.Mutex
+	state    checkpointState
+	log      *os.File
+	lastSync time.Time
+}
+
+func NewCheckpoint(path, root string) *Checkpoint {
+	return &Checkpoint{
+		Path: path,
+		state: checkpointState{
+			Root: root,
+			Done: make(map[string]bool),
+		},
+	}
+}
+
+func (c *Checkpoint) logPath() string {
+	return c.Path + ".log"
+}
+
+// Load reads a previous checkpoint and replays its log. A missing file is not
+// an error.
+func (c *Checkpoint) Load() (bool, error) {
+	data, err := os.ReadFile(c.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("error reading checkpoint: %w", err)
+	}
+
+	var state checkpointState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return false, fmt.Errorf("error parsing checkpoint: %w", err)
+	}
+	if state.Root != c.state.Root {
+		return false, fmt.Errorf("checkpoint belongs to %s, not %s", state.Root, c.state.Root)
+	}
+	if state.Done == nil {
+		state.Done = make(map[string]bool)
+	}
+
+	if err := replayCheckpointLog(c.logPath(), state.Done); err != nil {
+		return false, err
+	}
+
+	c.mu.Lock()
+	c.state = state
+	c.mu.Unlock()
+
+	return true, nil
+}
+
+// replayCheckpointLog applies the logged outcomes to done. A torn last line
+// from a crash is ignored.
+func replayCheckpointLog(path string, done map[string]bool) error {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error reading checkpoint log: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var e checkpointEntry
+		if json.Unmarshal(scanner.Bytes(), &e) == nil && e.Path != "" {
+			done[e.Path] = e.OK
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("error reading checkpoint log: %w", err)
+	}
+	return nil
+}
+
+// Completed returns the number of sources recorded as converted.
+func (c *Checkpoint) Completed() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	n := 0
+	for _, ok := range c.state.Done {
+		if ok {
+			n++
+		}
+	}
+	return n
+}
+
+// IsDone reports whether path was converted successfully in an earlier run.
+// Failed files are retried.
+func (c *Checkpoint) IsDone(path string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.state.Done[c.key(path)]
+}
+
+// Mark records the outcome for path in the log, syncing it if the last sync
+// is older than checkpointInterval.
+func (c *Checkpoint) Mark(path string, success bool) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := c.key(path)
+	c.state.Done[key] = success
+
+	if c.log == nil {
+		// The checkpoint is written first, so the log always has one to
+		// belong to.
+		if err := c.compact(); err != nil {
+			return err
+		}
+		f, err := os.OpenFile(c.logPath(), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+		if err != nil {
+			return fmt.Errorf("error opening checkpoint log: %w", err)
+		}
+		c.log = f
+		c.lastSync = time.Now()
+	}
+
+	line, err := json.Marshal(checkpointEntry{Path: key, OK: success})
+	if err != nil {
+		return fmt.Errorf("error encoding checkpoint entry: %w", err)
+	}
+	if _, err := c.log.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("error writing checkpoint log: %w", err)
+	}
+
+	if time.Since(c.lastSync) < checkpointInterval {
+		return nil
+	}
+	c.lastSync = time.Now()
+	if err := c.log.Sync(); err != nil {
+		return fmt.Errorf("error syncing checkpoint log: %w", err)
+	}
+	return nil
+}
+
+// Flush compacts the log into the checkpoint.
+func (c *Checkpoint) Flush() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.compact()
+}
+
+// Remove deletes the checkpoint and its log once a batch has finished.
+func (c *Checkpoint) Remove() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.closeLog()
+	for _, path := range []string{c.logPath(), c.Path} {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("error removing checkpoint: %w", err)
+		}
+	}
+
+	// Leave no empty state folder behind in the source tree.
+	if dir := filepath.Dir(c.Path); filepath.Base(dir) == backupFolderName {
+		os.Remove(dir)
+	}
+	return nil
+}
+
+// compact writes the whole state atomically and then drops the log it
+// replaces.
+func (c *Checkpoint) compact() error {
+	c.closeLog()
+
+	if err := c.save(); err != nil {
+		return err
+	}
+	if err := os.Remove(c.logPath()); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("error removing checkpoint log: %w", err)
+	}
+	return nil
+}
+
+func (c *Checkpoint) closeLog() {
+	if c.log != nil {
+		c.log.Close()
+		c.log = nil
+	}
+}
+
+func (c *Checkpoint) save() error {
+	c.state.Updated = time.Now()
+
+	data, err := json.Marshal(c.state)
+	if err != nil {
+		return fmt.Errorf("error encoding checkpoint: %w", err)
+	}
+
+	if err := writeFileAtomic(c.Path, data, 0o644); err != nil {
+		return fmt.Errorf("error writing checkpoint: %w", err)
+	}
+	return nil
+}
+
+func (c *Checkpoint) key(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	if rel, err := filepath.Rel(c.state.Root, path); err == nil {
+		return filepath.ToSlash(rel)
+	}
+	return filepath.ToSlash(path)
+}
+
+// writeFileAtomic writes data to a sibling temp file, syncs it and renames it
+// over path, so readers see either the old or the new content.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), tempFilePrefix+"*")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(tmpPath, perm)
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, path)
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	return syncDir(filepath.Dir(path))
+}
+
+func isTempFile(name string) bool {
+	return strings.HasPrefix(name, tempFilePrefix)
+}
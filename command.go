@@ -4,9 +4,11 @@ import (
 	"flag"
 	"fmt"
 	"image"
+	"log/slog"
 	"os"
 	"runtime"
 	"strings"
+	"time"
 
 	"avifconv/logger"
 
@@ -21,6 +23,81 @@ type Config struct {
 	QualityAlpha int
 	Speed        int
 	QueueSize    int
+	Verify       bool
+	VerifyPSNR   float64
+
+	BackupMode      BackupMode
+	BackupDir       string
+	BackupRetention int
+	JournalPath     string
+
+	Resume         bool
+	CheckpointPath string
+
+	OutDir       string
+	Incremental  bool
+	PruneOutputs bool
+
+	Dedup DedupMode
+
+	IncludeExtensionless bool
+
+	PreserveMode  bool
+	PreserveTimes bool
+	PreserveOwner bool
+
+	TempDir string
+
+	MemoryBudget int
+	Order        JobOrder
+	Dashboard    bool
+
+	Timeout     time.Duration
+	SlowWarning time.Duration
+
+	Retries      int
+	RetryBackoff time.Duration
+	FailuresFile string
+
+	MaxFailures int
+	FailRatio   float64
+
+	ReportPath   string
+	ReportFormat ReportFormat
+	HTMLReport   string
+
+	MetricsFile string
+	MetricsAddr string
+
+	LogFormat LogFormat
+	LogLevel  slog.Level
+	LogSource bool
+	Quiet     bool
+	Color     logger.ColorMode
+
+	LogFile        string
+	LogFileLevel   slog.Level
+	LogFileFormat  LogFormat
+	LogFileRotate  string
+	LogFileMaxSize int
+	LogFileKeep    int
+}
+
+type LogFormat string
+
+const (
+	LogFormatText LogFormat = "text"
+	LogFormatJSON LogFormat = "json"
+)
+
+func parseLogFormat(s string) (LogFormat, error) {
+	switch f := LogFormat(strings.ToLower(s)); f {
+	case LogFormatText, LogFormatJSON:
+		return f, nil
+	case "":
+		return LogFormatText, nil
+	}
+	return "", fmt.Errorf("error: unknown log format %q (text, json)", s)
 }
 
 var (
@@ -42,6 +119,68 @@ func ParseConfig(console *logger.Console) (*Config, error) {
 	flag.IntVar(&cfg.Quality, "quality", 80, "Image quality (0-100, higher is better)")
 	flag.IntVar(&cfg.QualityAlpha, "quality-alpha", 80, "Alpha channel quality (0-100)")
 	flag.IntVar(&cfg.Speed, "speed", 6, "Encoding speed (0-10, lower is better quality but slower)")
+	flag.BoolVar(&cfg.Verify, "verify", true, "Decode and check each output before the original is removed")
+	flag.Float64Var(&cfg.VerifyPSNR, "verify-psnr", 0, "Minimum PSNR in dB an output must reach to pass verification (0 disables)")
+
+	backupMode := flag.String("backup", "none", "Keep originals instead of deleting them: none, mirror, folder, tar, zip")
+	flag.StringVar(&cfg.BackupDir, "backup-dir", "", "Directory for mirror/tar/zip backups (default: <input>/.avifconv-backup)")
+	flag.IntVar(&cfg.BackupRetention, "backup-retention", 0, "Number of newest backup sets to keep after the run (0 keeps all)")
+
+	flag.StringVar(&cfg.JournalPath, "journal", "", "Path of the run journal used by undo (default: <input>/.avifconv-backup/journal-<timestamp>.jsonl)")
+
+	flag.BoolVar(&cfg.Resume, "resume", false, "Continue an interrupted batch from its checkpoint")
+	flag.StringVar(&cfg.CheckpointPath, "checkpoint", "", "Path of the batch checkpoint (default: <input>/.avifconv-backup/checkpoint.json)")
+
+	flag.StringVar(&cfg.OutDir, "out-dir", "", "Write AVIF files to this directory, mirroring the input tree, and keep the originals")
+	flag.BoolVar(&cfg.Incremental, "incremental", false, "Skip sources whose output is up to date (requires --out-dir and a directory input)")
+	flag.BoolVar(&cfg.PruneOutputs, "prune-outputs", false, "Remove outputs whose source was deleted (requires --incremental)")
+
+	dedupMode := flag.String("dedup", "off", "Encode identical sources once and reuse the output: off, link, copy")
+
+	flag.BoolVar(&cfg.IncludeExtensionless, "include-extensionless", false, "Also convert files without an extension when their content is a supported image")
+
+	flag.BoolVar(&cfg.PreserveMode, "preserve-mode", true, "Copy the source's permission bits onto the output (otherwise outputs are 0644, regardless of umask)")
+	flag.BoolVar(&cfg.PreserveTimes, "preserve-times", true, "Copy the source's access and modification times onto the output")
+	flag.BoolVar(&cfg.PreserveOwner, "preserve-owner", true, "Copy the source's owner and group onto the output when running as root")
+
+	flag.StringVar(&cfg.TempDir, "temp-dir", "", "Directory for temporary files (default: next to each output)")
+
+	flag.IntVar(&cfg.MemoryBudget, "memory-budget", 4096, "Approximate memory in MB that images being converted may use at once (0 disables)")
+
+	order := flag.String("order", "walk", "Job order: walk, largest, largest-pixels, smallest, directory")
+
+	flag.BoolVar(&cfg.Dashboard, "dashboard", true, "Show live per-worker activity above the progress bar when stdout is a terminal")
+
+	flag.DurationVar(&cfg.Timeout, "timeout", 0, "Abandon a file whose encode takes longer than this, e.g. 5m (0 disables; encodes then run in a child process)")
+	flag.DurationVar(&cfg.SlowWarning, "slow-warning", time.Minute, "Warn when a single file has been converting longer than this (0 disables)")
+
+	flag.IntVar(&cfg.Retries, "retries", 2, "Retries for transient I/O errors such as EAGAIN or EBUSY")
+	flag.DurationVar(&cfg.RetryBackoff, "retry-backoff", 500*time.Millisecond, "Delay before the first retry, doubled on each further attempt")
+	flag.StringVar(&cfg.FailuresFile, "failures-file", "", "Write failed paths with their category and reason to this file (tab-separated)")
+
+	flag.IntVar(&cfg.MaxFailures, "max-failures", 0, "Abort the batch after this many failed files (0 disables)")
+	flag.Float64Var(&cfg.FailRatio, "fail-ratio", 0, "Abort the batch when more than this fraction of processed files failed, e.g. 0.2 (0 disables)")
+
+	flag.StringVar(&cfg.ReportPath, "report", "", "Write a per-file report with a final summary to this file")
+	reportFormat := flag.String("report-format", "", "Report format: json or ndjson (default from the report's extension)")
+	flag.StringVar(&cfg.HTMLReport, "html-report", "", "Write a self-contained HTML report with PSNR scores and before/after thumbnails")
+
+	flag.StringVar(&cfg.MetricsFile, "metrics-file", "", "Write Prometheus metrics to this file (e.g. for node_exporter's textfile collector)")
+	flag.StringVar(&cfg.MetricsAddr, "metrics-addr", "", "Serve Prometheus metrics on http://ADDR/metrics while the batch runs, e.g. 127.0.0.1:9470")
+
+	logFormat := flag.String("log-format", "text", "Log format: text or json (one object per line, no colors)")
+	logLevel := flag.String("log-level", "info", "Minimum log level: debug, verbose, info, warn or error")
+	flag.BoolVar(&cfg.Quiet, "q", false, "Only print errors, without the progress bar or summary (--log-level error)")
+	verbose := flag.Bool("v", false, "Also print one line per converted file with its sizes and ratio (--log-level verbose)")
+	veryVerbose := flag.Bool("vv", false, "Also print phase timings and encoder options per file (--log-level debug)")
+	flag.BoolVar(&cfg.LogSource, "log-source", false, "Include the source location in log records")
+	color := flag.String("color", "auto", "Colored output: auto (terminal only; honours NO_COLOR and CLICOLOR_FORCE), always or never")
+	flag.StringVar(&cfg.LogFile, "log-file", "", "Also write the log to this file, without colors")
+	logFileLevel := flag.String("log-file-level", "debug", "Minimum level for --log-file: debug, verbose, info, warn or error")
+	logFileFormat := flag.String("log-file-format", "text", "Format for --log-file: text or json")
+	flag.StringVar(&cfg.LogFileRotate, "log-file-rotate", "run", "Rotate --log-file at the start of each run (run) or when it exceeds --log-file-max-size (size)")
+	flag.IntVar(&cfg.LogFileMaxSize, "log-file-max-size", 10, "Size in MB at which --log-file is rotated with --log-file-rotate size")
+	flag.IntVar(&cfg.LogFileKeep, "log-file-keep", 5, "Number of rotated log files to keep")
 
 	showVersion := flag.Bool("version", false, "Show version information")
 
@@ -60,6 +199,8 @@ func ParseConfig(console *logger.Console) (*Config, error) {
 
 	if len(args) == 0 {
 		console.Info("Usage: avifconv [options] <file or directory path>")
+		console.Info("       avifconv undo [--force] <journal>")
+		console.Info("       avifconv prune-backups [--backup-retention N] [--backup-dir DIR] <directory path>")
 		console.Info("Options:")
 
 		old := flag.CommandLine.Output()
@@ -85,19 +226,86 @@ func ParseConfig(console *logger.Console) (*Config, error) {
 		return nil, fmt.Errorf("no input path specified")
 	}
 
+	mode, err := parseBackupMode(*backupMode)
+	if err != nil {
+		return nil, err
+	}
+	cfg.BackupMode = mode
+
+	if cfg.Dedup, err = parseDedupMode(*dedupMode); err != nil {
+		return nil, err
+	}
+	if cfg.Order, err = parseJobOrder(*order); err != nil {
+		return nil, err
+	}
+	if cfg.ReportFormat, err = parseReportFormat(*reportFormat, cfg.ReportPath); err != nil {
+		return nil, err
+	}
+	if cfg.LogFormat, err = parseLogFormat(*logFormat); err != nil {
+		return nil, err
+	}
+	if cfg.LogLevel, err = logger.ParseLevel(*logLevel); err != nil {
+		return nil, fmt.Errorf("error: unknown log level %q (debug, verbose, info, warn, error)", *logLevel)
+	}
+	if cfg.LogLevel, err = verbosityLevel(cfg.LogLevel, cfg.Quiet, *verbose, *veryVerbose); err != nil {
+		return nil, err
+	}
+	if cfg.Color, err = logger.ParseColorMode(*color); err != nil {
+		return nil, err
+	}
+	if cfg.LogFileFormat, err = parseLogFormat(*logFileFormat); err != nil {
+		return nil, err
+	}
+	if cfg.LogFileLevel, err = logger.ParseLevel(*logFileLevel); err != nil {
+		return nil, fmt.Errorf("error: unknown log file level %q (debug, verbose, info, warn, error)", *logFileLevel)
+	}
+
 	if err := cfg.validate(); err != nil {
 		return nil, err
 	}
 
 	cfg.InputPath = args[0]
 
-	if _, err := os.Stat(cfg.InputPath); err != nil {
+	info, err := os.Stat(cfg.InputPath)
+	if err != nil {
 		return nil, fmt.Errorf("error: %v", err)
 	}
+	if cfg.Incremental && !info.IsDir() {
+		return nil, fmt.Errorf("error: incremental requires a directory input")
+	}
 
 	return cfg, nil
 }
 
+// verbosityLevel applies -q, -v or -vv, which are shorthands for
+// --log-level and cannot be combined with it or with each other.
+func verbosityLevel(level slog.Level, quiet, verbose, veryVerbose bool) (slog.Level, error) {
+	var set []slog.Level
+	if quiet {
+		set = append(set, slog.LevelError)
+	}
+	if verbose {
+		set = append(set, logger.LevelVerbose)
+	}
+	if veryVerbose {
+		set = append(set, slog.LevelDebug)
+	}
+	if len(set) == 0 {
+		return level, nil
+	}
+
+	logLevelSet := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "log-level" {
+			logLevelSet = true
+		}
+	})
+	if len(set) > 1 || logLevelSet {
+		return 0, fmt.Errorf("error: use only one of -q, -v, -vv and --log-level")
+	}
+	return set[0], nil
+}
+
 func (cfg *Config) validate() error {
 	if cfg.Quality < 0 || cfg.Quality > 100 {
 		return fmt.Errorf("error: quality must be in range 0-100")
@@ -108,9 +316,139 @@ func (cfg *Config) validate() error {
 	if cfg.Speed < 0 || cfg.Speed > 10 {
 		return fmt.Errorf("error: encoding speed must be in range 0-10")
 	}
+	if cfg.VerifyPSNR < 0 {
+		return fmt.Errorf("error: verify-psnr must not be negative")
+	}
+	if cfg.BackupRetention < 0 {
+		return fmt.Errorf("error: backup-retention must not be negative")
+	}
+	if cfg.OutDir != "" && cfg.BackupMode != BackupNone {
+		return fmt.Errorf("error: backup cannot be used with out-dir, originals are kept")
+	}
+	if cfg.Incremental && cfg.OutDir == "" {
+		return fmt.Errorf("error: incremental requires out-dir")
+	}
+	if cfg.PruneOutputs && !cfg.Incremental {
+		return fmt.Errorf("error: prune-outputs requires incremental")
+	}
+	if cfg.Timeout < 0 || cfg.SlowWarning < 0 {
+		return fmt.Errorf("error: timeout and slow-warning must not be negative")
+	}
+	if cfg.Retries < 0 || cfg.RetryBackoff < 0 {
+		return fmt.Errorf("error: retries and retry-backoff must not be negative")
+	}
+	if cfg.MaxFailures < 0 {
+		return fmt.Errorf("error: max-failures must not be negative")
+	}
+	if cfg.FailRatio < 0 || cfg.FailRatio > 1 {
+		return fmt.Errorf("error: fail-ratio must be in range 0-1")
+	}
+	if cfg.LogFileRotate != "run" && cfg.LogFileRotate != "size" {
+		return fmt.Errorf("error: log-file-rotate must be run or size")
+	}
+	if cfg.LogFileMaxSize < 1 || cfg.LogFileKeep < 0 {
+		return fmt.Errorf("error: log-file-max-size must be at least 1 and log-file-keep must not be negative")
+	}
+	if err := validateMetricsAddr(cfg.MetricsAddr); err != nil {
+		return err
+	}
+	if cfg.MemoryBudget < 0 {
+		return fmt.Errorf("error: memory-budget must not be negative")
+	}
+	if cfg.TempDir != "" {
+		if info, err := os.Stat(cfg.TempDir); err != nil || !info.IsDir() {
+			return fmt.Errorf("error: temp-dir %s is not a directory", cfg.TempDir)
+		}
+	}
+	return nil
+}
+
+func RunPruneBackups(console *logger.Console, args []string) error {
+	fs := flag.NewFlagSet("prune-backups", flag.ContinueOnError)
+	keep := fs.Int("backup-retention", 5, "Number of newest backup sets to keep")
+	dir := fs.String("backup-dir", "", "Custom backup directory to prune instead of searching for .avifconv-backup folders")
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *keep < 0 {
+		return fmt.Errorf("error: backup-retention must not be negative")
+	}
+
+	var removed int
+	var err error
+	switch {
+	case *dir != "":
+		removed, err = pruneBackupSets(*dir, *keep)
+	case fs.NArg() > 0:
+		removed, err = pruneBackups(fs.Arg(0), *keep)
+	default:
+		return fmt.Errorf("no directory specified")
+	}
+	if err != nil {
+		return err
+	}
+
+	console.Success("Pruned %d backup set(s), keeping the newest %d", removed, *keep)
 	return nil
 }
 
+func RunUndo(console *logger.Console, args []string) error {
+	fs := flag.NewFlagSet("undo", flag.ContinueOnError)
+	force := fs.Bool("force", false, "Restore even if files changed since the run")
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return fmt.Errorf("no journal specified")
+	}
+
+	return undoJournal(console, fs.Arg(0), *force)
+}
+
+// LoggerOptions builds the console options for the --log-* flags, opening
+// the log file if one is requested.
+func (cfg *Config) LoggerOptions() (*logger.RichLoggerOptions, error) {
+	opts := logger.DefaultOptions()
+	opts.Level = cfg.LogLevel
+	opts.AddSource = cfg.LogSource
+	opts.Quiet = cfg.Quiet
+	opts.EnableColors = logger.ColorEnabled(cfg.Color, opts.Output)
+
+	if cfg.LogFormat == LogFormatJSON {
+		opts.EnableJSON = true
+		opts.CompactJSON = true
+		opts.EnableColors = false
+		opts.TimeFormat = time.RFC3339Nano
+	}
+
+	if cfg.LogFile != "" {
+		var maxSize int64
+		if cfg.LogFileRotate == "size" {
+			maxSize = int64(cfg.LogFileMaxSize) * 1024 * 1024
+		}
+
+		f, err := logger.OpenRotatingFile(cfg.LogFile, maxSize, cfg.LogFileKeep, cfg.LogFileRotate == "run")
+		if err != nil {
+			return nil, err
+		}
+
+		out := logger.Output{
+			Writer:  f,
+			Level:   cfg.LogFileLevel,
+			JSON:    cfg.LogFileFormat == LogFormatJSON,
+			Compact: true,
+		}
+		if out.JSON {
+			out.TimeFormat = time.RFC3339Nano
+		}
+		opts.Outputs = append(opts.Outputs, out)
+	}
+
+	return opts, nil
+}
+
 func (cfg *Config) GetEncodingOptions() avif.Options {
 	return avif.Options{
 		Quality:           cfg.Quality,
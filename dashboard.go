@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"avifconv/logger"
+)
+
+const (
+	dashboardInterval  = 250 * time.Millisecond
+	recentFailureLimit = 5
+	dashboardNameWidth = 40
+)
+
+// startDashboard redraws worker activity, throughput and recent failures
+// above the progress bar until the returned stop function is called.
+func (p *Processor) startDashboard(statuses []workerStatus, stats *ProcessStats, bar *logger.ProgressBar) func() {
+	bar.SetDetached(true)
+
+	started := time.Now()
+	done := make(chan struct{})
+	finished := make(chan struct{})
+
+	go func() {
+		defer close(finished)
+
+		ticker := time.NewTicker(dashboardInterval)
+		defer ticker.Stop()
+
+		for {
+			p.dashboard.Draw(p.dashboardLines(statuses, stats, bar, started))
+
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-finished
+		p.dashboard.Clear()
+		bar.SetDetached(false)
+	}
+}
+
+func (p *Processor) dashboardLines(statuses []workerStatus, stats *ProcessStats, bar *logger.ProgressBar, started time.Time) []string {
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+
+	lines := make([]string, 0, len(statuses)+recentFailureLimit+5)
+	lines = append(lines, fmt.Sprintf("%-7s %-*s %s", "Worker", dashboardNameWidth, "File", "Time"))
+
+	for i, st := range statuses {
+		if !st.Busy {
+			lines = append(lines, fmt.Sprintf("#%-6d %-*s", i+1, dashboardNameWidth, "(idle)"))
+			continue
+		}
+		name := logger.Truncate(filepath.Base(st.CurrentFile), dashboardNameWidth)
+		lines = append(lines, fmt.Sprintf("#%-6d %s %s", i+1, logger.PadRight(name, dashboardNameWidth),
+			time.Since(st.StartTime).Round(100*time.Millisecond)))
+	}
+
+	elapsed := time.Since(started).Seconds()
+	var filesPerSec, mbPerSec float64
+	if elapsed > 0 {
+		filesPerSec = float64(stats.ProcessedFiles) / elapsed
+		mbPerSec = float64(stats.TotalOriginalSize) / 1024 / 1024 / elapsed
+	}
+	lines = append(lines, fmt.Sprintf("Throughput: %.1f files/s, %.2f MB/s", filesPerSec, mbPerSec))
+
+	if len(stats.recentFailures) > 0 {
+		lines = append(lines, "Recent failures:")
+		for _, f := range stats.recentFailures {
+			lines = append(lines, "  "+f)
+		}
+	}
+
+	return append(lines, bar.Line())
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"avifconv/logger"
+)
+
+type DedupMode string
+
+const (
+	DedupOff  DedupMode = "off"
+	DedupLink DedupMode = "link"
+	DedupCopy DedupMode = "copy"
+)
+
+func parseDedupMode(s string) (DedupMode, error) {
+	switch m := DedupMode(strings.ToLower(s)); m {
+	case DedupOff, DedupLink, DedupCopy:
+		return m, nil
+	case "":
+		return DedupOff, nil
+	}
+	return "", fmt.Errorf("error: unknown dedup mode %q (off, link, copy)", s)
+}
+
+// duplicateSet is a source that is encoded once and the sources with
+// identical content that reuse its output.
+type duplicateSet struct {
+	Hash       string
+	Duplicates []string
+}
+
+// groupDuplicates hashes files in parallel and returns the first file of each
+// distinct content, in the original order, with the others attached.
+func (p *Processor) groupDuplicates(files []string) ([]string, map[string]*duplicateSet, error) {
+	hashes := make([]string, len(files))
+	errs := make([]error, len(files))
+
+	workers := p.NumWorkers
+	if workers < 1 {
+		workers = 1
+	}
+
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				hashes[i], errs[i] = hashFile(files[i])
+			}
+		}()
+	}
+	for i := range files {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	primaries := make([]string, 0, len(files))
+	sets := make(map[string]*duplicateSet)
+	byHash := make(map[string]string)
+
+	for i, f := range files {
+		if errs[i] != nil {
+			return nil, nil, fmt.Errorf("error hashing %s: %w", f, errs[i])
+		}
+
+		if primary, ok := byHash[hashes[i]]; ok {
+			sets[primary].Duplicates = append(sets[primary].Duplicates, f)
+			continue
+		}
+
+		byHash[hashes[i]] = f
+		sets[f] = &duplicateSet{Hash: hashes[i]}
+		primaries = append(primaries, f)
+	}
+
+	for primary, set := range sets {
+		if len(set.Duplicates) == 0 {
+			delete(sets, primary)
+		}
+	}
+
+	return primaries, sets, nil
+}
+
+// writeDuplicate places the output already encoded for a source with the same
+// content at the destination for dup, then handles dup like a converted file.
+func (p *Processor) writeDuplicate(primaryOutput, outputHash string, dup string, set *duplicateSet) (int64, int64, error) {
+	fileInfo, err := os.Stat(dup)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to get file info: %w", err)
+	}
+	originalSize := fileInfo.Size()
+
+	outputPath := p.outputPath(dup)
+	if outputPath != primaryOutput {
+		if err := os.MkdirAll(filepath.Dir(outputPath), 0o755); err != nil {
+			return originalSize, 0, fmt.Errorf("error creating output directory: %w", err)
+		}
+		if err := p.placeDuplicate(primaryOutput, outputPath, fileInfo); err != nil {
+			return originalSize, 0, err
+		}
+	}
+
+	outInfo, err := os.Stat(outputPath)
+	if err != nil {
+		return originalSize, 0, fmt.Errorf("failed to get compressed file info: %w", err)
+	}
+	compressedSize := outInfo.Size()
+
+	var backupLocation *BackupLocation
+	if p.OutDir == "" {
+		backupLocation, err = p.removeOriginal(dup)
+		if err != nil {
+			return originalSize, compressedSize, err
+		}
+	}
+
+	if p.Cache != nil {
+		p.Cache.Update(dup, set.Hash, fileInfo, outputPath, compressedSize)
+	}
+
+	p.recordJournal(JournalEntry{
+		Time:       time.Now(),
+		Source:     dup,
+		Output:     outputPath,
+		SourceHash: set.Hash,
+		OutputHash: outputHash,
+		SourceSize: originalSize,
+		OutputSize: compressedSize,
+		Backup:     backupLocation,
+	})
+
+	return originalSize, compressedSize, nil
+}
+
+// placeDuplicate hard-links or copies src to a temp name next to dst and
+// renames it into place. Links fall back to copies across filesystems; only
+// copies get the metadata of their own source, since links share an inode.
+func (p *Processor) placeDuplicate(src, dst string, srcInfo os.FileInfo) error {
+	tmp := filepath.Join(filepath.Dir(dst), fmt.Sprintf("%sdup-%d-%s", tempFilePrefix, os.Getpid(), filepath.Base(dst)))
+
+	linked := p.Dedup == DedupLink && os.Link(src, tmp) == nil
+	if !linked {
+		if err := copyFile(src, tmp); err != nil {
+			return err
+		}
+		if err := p.applyMetadata(tmp, srcInfo); err != nil {
+			os.Remove(tmp)
+			return err
+		}
+	}
+
+	if err := os.Rename(tmp, dst); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("error renaming file: %w", err)
+	}
+
+	return syncDir(filepath.Dir(dst))
+}
+
+// duplicateCount returns how many files are reused from another file's
+// output.
+func (p *Processor) duplicateCount() int {
+	n := 0
+	for _, set := range p.duplicates {
+		n += len(set.Duplicates)
+	}
+	return n
+}
+
+// processDuplicates fans the result for primary out to its duplicates. If the
+// primary failed, its duplicates fail with it.
+func (p *Processor) processDuplicates(primary string, set *duplicateSet, primaryErr error, encodeTime time.Duration,
+	stats *ProcessStats, bar *logger.ProgressBar) {
+	primaryOutput := p.outputPath(primary)
+
+	var outputHash string
+	err := primaryErr
+	if err == nil {
+		outputHash, err = hashFile(primaryOutput)
+	}
+	if err != nil {
+		err = fmt.Errorf("duplicate of %s, which failed: %w", filepath.Base(primary), err)
+	}
+
+	for _, dup := range set.Duplicates {
+		var origSize, compSize int64
+		dupErr := err
+		if dupErr == nil {
+			origSize, compSize, dupErr = p.writeDuplicate(primaryOutput, outputHash, dup, set)
+		}
+
+		rec := p.newFileRecord(dup, 0)
+		rec.Output = p.outputPath(dup)
+		rec.Status = StatusDuplicate
+		rec.setResult(origSize, compSize, dupErr)
+		p.addRecord(rec)
+
+		stats.mu.Lock()
+		stats.ProcessedFiles++
+		if dupErr != nil {
+			stats.FailedFiles++
+			stats.recordFailure(dup, dupErr)
+			p.Console.Errorw("Error processing file", "file", dup, "duplicate_of", primary,
+				"category", classifyError(dupErr), "error", dupErr)
+		} else {
+			stats.DuplicateFiles++
+			stats.DuplicateTimeSaved += encodeTime
+			stats.LastSuccess = time.Now()
+			stats.TotalOriginalSize += origSize
+			stats.TotalCompressedSize += compSize
+		}
+		bar.Increment(1)
+		stats.mu.Unlock()
+
+		if dupErr == nil {
+			p.Console.Verbosew("Reused duplicate", "file", dup, "duplicate_of", primary, "output", rec.Output,
+				"original_size", origSize, "compressed_size", compSize)
+		}
+
+		if cerr := p.Checkpoint.Mark(dup, dupErr == nil); cerr != nil {
+			p.Console.Warn("Checkpoint not updated: %v", cerr)
+		}
+	}
+}
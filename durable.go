@@ -0,0 +1,56 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"syscall"
+)
+
+// syncDir flushes a directory entry to disk so a preceding rename survives a
+// crash. Windows cannot sync directories, so it is a no-op there.
+func syncDir(dir string) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	return d.Sync()
+}
+
+// stageNextTo makes sure tempPath is on the same filesystem as dst so the
+// final rename is atomic. A temp file on another filesystem is copied to a
+// new temp file in dst's directory and removed. It returns the path to rename.
+func stageNextTo(tempPath, dst string, srcInfo os.FileInfo, applyMetadata func(string, os.FileInfo) error) (string, error) {
+	dir := filepath.Dir(dst)
+	if filepath.Dir(tempPath) == dir {
+		return tempPath, nil
+	}
+
+	staged := filepath.Join(dir, filepath.Base(tempPath))
+	err := os.Rename(tempPath, staged)
+	if err == nil {
+		return staged, nil
+	}
+	if !errors.Is(err, syscall.EXDEV) {
+		return tempPath, fmt.Errorf("error moving temporary file: %w", err)
+	}
+
+	if err := copyFile(tempPath, staged); err != nil {
+		return tempPath, err
+	}
+	if err := applyMetadata(staged, srcInfo); err != nil {
+		os.Remove(staged)
+		return tempPath, err
+	}
+	os.Remove(tempPath)
+
+	return staged, nil
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+type ErrorCategory string
+
+const (
+	CategoryDecode       ErrorCategory = "decode"
+	CategoryEncode       ErrorCategory = "encode"
+	CategoryIO           ErrorCategory = "io"
+	CategoryPermission   ErrorCategory = "permission"
+	CategoryVerification ErrorCategory = "verification"
+	CategoryTimeout      ErrorCategory = "timeout"
+)
+
+// errorCategories fixes the order categories are reported in.
+var errorCategories = []ErrorCategory{
+	CategoryDecode,
+	CategoryEncode,
+	CategoryIO,
+	CategoryPermission,
+	CategoryVerification,
+	CategoryTimeout,
+}
+
+// ProcessError tags a conversion failure with its category. Errors without
+// a tag are classified as I/O or permission failures.
+type ProcessError struct {
+	Category ErrorCategory
+	Err      error
+}
+
+func (e *ProcessError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ProcessError) Unwrap() error {
+	return e.Err
+}
+
+func categorized(category ErrorCategory, err error) error {
+	return &ProcessError{Category: category, Err: err}
+}
+
+// decodeFailure tags err from an image decoder as a decode failure, unless
+// the decoder only passed on a failure to read the file, which stays an I/O
+// or permission error and may be retried.
+func decodeFailure(err error) error {
+	var pathErr *fs.PathError
+	var errno syscall.Errno
+	if errors.As(err, &pathErr) || errors.As(err, &errno) {
+		return err
+	}
+	return categorized(CategoryDecode, err)
+}
+
+func classifyError(err error) ErrorCategory {
+	var perr *ProcessError
+	var verr *VerificationError
+	var terr *TimeoutError
+
+	switch {
+	case errors.As(err, &perr):
+		return perr.Category
+	case errors.As(err, &verr):
+		return CategoryVerification
+	case errors.As(err, &terr):
+		return CategoryTimeout
+	case errors.Is(err, os.ErrPermission):
+		return CategoryPermission
+	}
+	return CategoryIO
+}
+
+// isTransient reports whether err is an I/O failure worth retrying, such as
+// a busy or briefly unavailable file on a network filesystem.
+func isTransient(err error) bool {
+	if classifyError(err) != CategoryIO {
+		return false
+	}
+
+	for _, errno := range []syscall.Errno{syscall.EAGAIN, syscall.EBUSY, syscall.EINTR, syscall.ETIMEDOUT, syscall.ESTALE} {
+		if errors.Is(err, errno) {
+			return true
+		}
+	}
+	return false
+}
+
+// processWithRetry converts filePath, retrying transient failures with
+// exponential backoff.
+func (p *Processor) processWithRetry(ctx context.Context, filePath string, rec *FileRecord) (int64, int64, error) {
+	for attempt := 0; ; attempt++ {
+		origSize, compSize, err := p.processWithinBudget(ctx, filePath, rec)
+		if err == nil || attempt >= p.Retries || !isTransient(err) {
+			return origSize, compSize, err
+		}
+
+		delay := p.RetryBackoff << attempt
+		p.Console.Warnw("Retrying file", "file", filePath, "delay", delay,
+			"attempt", attempt+2, "attempts", p.Retries+1, "error", err)
+
+		select {
+		case <-ctx.Done():
+			return origSize, compSize, err
+		case <-time.After(delay):
+		}
+	}
+}
+
+type failureRecord struct {
+	Path     string
+	Category ErrorCategory
+	Reason   string
+}
+
+// recordFailure counts a failure by category and keeps it for the failures
+// file and the dashboard. Callers hold stats.mu.
+func (stats *ProcessStats) recordFailure(filePath string, err error) {
+	category := classifyError(err)
+	if stats.FailuresByCategory == nil {
+		stats.FailuresByCategory = make(map[ErrorCategory]int)
+	}
+	stats.FailuresByCategory[category]++
+
+	stats.failures = append(stats.failures, failureRecord{Path: filePath, Category: category, Reason: err.Error()})
+
+	stats.recentFailures = append(stats.recentFailures, fmt.Sprintf("%s: %v", filepath.Base(filePath), err))
+	if len(stats.recentFailures) > recentFailureLimit {
+		stats.recentFailures = stats.recentFailures[1:]
+	}
+}
+
+// writeFailures writes one tab-separated line per failed file: path,
+// category and reason.
+func writeFailures(path string, failures []failureRecord) error {
+	var sb strings.Builder
+	for _, f := range failures {
+		reason := strings.NewReplacer("\t", " ", "\n", " ").Replace(f.Reason)
+		fmt.Fprintf(&sb, "%s\t%s\t%s\n", f.Path, f.Category, reason)
+	}
+
+	if err := writeFileAtomic(path, []byte(sb.String()), 0o644); err != nil {
+		return fmt.Errorf("error writing failures file: %w", err)
+	}
+	return nil
+}
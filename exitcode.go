@@ -0,0 +1,60 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Process exit codes.
+const (
+	ExitSuccess        = 0
+	ExitPartialFailure = 1
+	ExitConfigError    = 2
+	ExitTotalFailure   = 3
+	ExitInterrupted    = 130
+)
+
+// BatchError reports a directory batch that did not fully succeed.
+type BatchError struct {
+	Failed      int
+	Succeeded   int
+	Aborted     bool
+	Interrupted bool
+}
+
+func (e *BatchError) Error() string {
+	if e.Failed+e.Succeeded == 0 && e.Interrupted {
+		return "interrupted before any file was processed"
+	}
+
+	msg := fmt.Sprintf("%d of %d files failed", e.Failed, e.Failed+e.Succeeded)
+	switch {
+	case e.Interrupted:
+		msg += "; interrupted"
+	case e.Aborted:
+		msg += "; batch aborted after exceeding the failure threshold"
+	}
+	return msg
+}
+
+// exitCode maps the result of ProcessPath to a process exit code.
+func exitCode(err error) int {
+	if err == nil {
+		return ExitSuccess
+	}
+
+	var berr *BatchError
+	if errors.As(err, &berr) {
+		switch {
+		case berr.Interrupted:
+			return ExitInterrupted
+		case berr.Aborted:
+			return ExitPartialFailure
+		case berr.Succeeded == 0:
+			return ExitTotalFailure
+		}
+		return ExitPartialFailure
+	}
+
+	return ExitTotalFailure
+}
@@ -0,0 +1,340 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"html/template"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/gen2brain/avif"
+)
+
+const (
+	// thumbnailSize bounds the longer side of the thumbnails embedded in the
+	// HTML report.
+	thumbnailSize = 240
+
+	// thumbnailQuality is the JPEG quality of opaque thumbnails.
+	thumbnailQuality = 85
+
+	// maxReportedPSNR stands in for identical images, whose PSNR is infinite.
+	maxReportedPSNR = 100
+
+	// highlightFraction is the share of files flagged as having the worst
+	// savings or the lowest scores.
+	highlightFraction = 0.1
+
+	// maxThumbnails is how many files keep their thumbnails under each
+	// ranking, worst savings and lowest PSNR, so neither memory nor the page
+	// grows with the batch.
+	maxThumbnails = 50
+)
+
+// captureComparison scores the encoded output against the source and keeps
+// thumbnails of both for the HTML report. The source may be gone by the time
+// the report is written, so this happens while the decoded image is at hand.
+// out is the output as decoded by verification; it is read from avifPath
+// only when verification is off.
+func (p *Processor) captureComparison(avifPath string, src, out image.Image, rec *FileRecord) error {
+	if out == nil {
+		f, err := os.Open(avifPath)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		if out, err = avif.Decode(f); err != nil {
+			return fmt.Errorf("cannot decode output: %w", err)
+		}
+	}
+
+	rec.PSNR = math.Min(computePSNR(src, out), maxReportedPSNR)
+
+	var err error
+	if rec.SourceThumb, err = thumbnailURL(src); err != nil {
+		return err
+	}
+	rec.OutputThumb, err = thumbnailURL(out)
+	return err
+}
+
+// thumbnailURL downscales img with a box filter and returns it as a data
+// URL: a JPEG, or a PNG when the image has transparency to keep.
+func thumbnailURL(img image.Image) (template.URL, error) {
+	thumb := thumbnail(img)
+
+	var buf bytes.Buffer
+	var err error
+	mime := "image/jpeg"
+	if hasAlpha(thumb) {
+		mime = "image/png"
+		err = png.Encode(&buf, thumb)
+	} else {
+		err = jpeg.Encode(&buf, thumb, &jpeg.Options{Quality: thumbnailQuality})
+	}
+	if err != nil {
+		return "", fmt.Errorf("cannot encode thumbnail: %w", err)
+	}
+	return template.URL("data:" + mime + ";base64," + base64.StdEncoding.EncodeToString(buf.Bytes())), nil
+}
+
+func thumbnail(img image.Image) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w <= thumbnailSize && h <= thumbnailSize {
+		return img
+	}
+
+	scale := float64(max(w, h)) / thumbnailSize
+	tw, th := max(1, int(float64(w)/scale)), max(1, int(float64(h)/scale))
+	dst := image.NewNRGBA(image.Rect(0, 0, tw, th))
+
+	for ty := 0; ty < th; ty++ {
+		y0, y1 := ty*h/th, (ty+1)*h/th
+		for tx := 0; tx < tw; tx++ {
+			x0, x1 := tx*w/tw, (tx+1)*w/tw
+
+			var r, g, bl, a, n uint64
+			for y := y0; y < y1; y++ {
+				for x := x0; x < x1; x++ {
+					cr, cg, cb, ca := img.At(b.Min.X+x, b.Min.Y+y).RGBA()
+					r, g, bl, a = r+uint64(cr), g+uint64(cg), bl+uint64(cb), a+uint64(ca)
+					n++
+				}
+			}
+			if n == 0 || a == 0 {
+				continue
+			}
+
+			// Average premultiplied values, then convert back for NRGBA.
+			i := dst.PixOffset(tx, ty)
+			dst.Pix[i+0] = uint8(r * 0xff / a)
+			dst.Pix[i+1] = uint8(g * 0xff / a)
+			dst.Pix[i+2] = uint8(bl * 0xff / a)
+			dst.Pix[i+3] = uint8(a / n >> 8)
+		}
+	}
+
+	return dst
+}
+
+type htmlRow struct {
+	*FileRecord
+	Name        string
+	Ratio       float64
+	Saving      float64
+	WorstSaving bool
+	LowScore    bool
+}
+
+// writeHTMLReport renders a self-contained page with a sortable table and a
+// before/after slider per converted file.
+func writeHTMLReport(path, root string, records []*FileRecord, summary ReportSummary) error {
+	rows := make([]*htmlRow, 0, len(records))
+	var converted []*htmlRow
+	for _, rec := range records {
+		row := &htmlRow{FileRecord: rec, Name: rec.Source}
+		if rel, err := filepath.Rel(root, rec.Source); err == nil {
+			row.Name = rel
+		}
+		if rec.OriginalSize > 0 && rec.CompressedSize > 0 {
+			row.Ratio = float64(rec.CompressedSize) / float64(rec.OriginalSize) * 100
+			row.Saving = 100 - row.Ratio
+		}
+		if rec.Status == StatusConverted {
+			converted = append(converted, row)
+		}
+		rows = append(rows, row)
+	}
+
+	highlight(converted, func(a, b *htmlRow) bool { return a.Saving < b.Saving },
+		func(r *htmlRow) { r.WorstSaving = true })
+	scored := converted[:0:0]
+	for _, row := range converted {
+		if row.PSNR > 0 {
+			scored = append(scored, row)
+		}
+	}
+	highlight(scored, func(a, b *htmlRow) bool { return a.PSNR < b.PSNR },
+		func(r *htmlRow) { r.LowScore = true })
+
+	// Files that grew are always worth a look.
+	for _, row := range converted {
+		if row.Saving < 0 {
+			row.WorstSaving = true
+		}
+	}
+
+	var buf bytes.Buffer
+	err := htmlReportTemplate.Execute(&buf, struct {
+		Summary    ReportSummary
+		Generated  string
+		Thumbnails int
+		Rows       []*htmlRow
+	}{summary, time.Now().Format(time.RFC1123), maxThumbnails, rows})
+	if err != nil {
+		return fmt.Errorf("error rendering HTML report: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("error creating report directory: %w", err)
+	}
+	return writeFileAtomic(path, buf.Bytes(), 0o644)
+}
+
+// highlight flags the worst highlightFraction of rows, at least one, by less.
+func highlight(rows []*htmlRow, less func(a, b *htmlRow) bool, flag func(*htmlRow)) {
+	if len(rows) < 2 {
+		return
+	}
+
+	sorted := append([]*htmlRow(nil), rows...)
+	sort.SliceStable(sorted, func(i, j int) bool { return less(sorted[i], sorted[j]) })
+
+	n := max(1, int(float64(len(sorted))*highlightFraction))
+	for _, row := range sorted[:n] {
+		flag(row)
+	}
+}
+
+// rankThumbnails keeps rec's thumbnails only while it is among the
+// maxThumbnails converted files with the worst savings or the lowest PSNR,
+// and drops those of a file pushed out of both. Callers hold r.mu.
+func (r *Report) rankThumbnails(rec *FileRecord) {
+	if rec.SourceThumb == "" {
+		return
+	}
+	if rec.Status != StatusConverted {
+		rec.SourceThumb, rec.OutputThumb = "", ""
+		return
+	}
+
+	var bySaving, byPSNR *FileRecord
+	r.bySaving, bySaving = insertWorst(r.bySaving, rec, func(a, b *FileRecord) bool { return a.sizeRatio() > b.sizeRatio() })
+	r.byPSNR, byPSNR = insertWorst(r.byPSNR, rec, func(a, b *FileRecord) bool { return a.PSNR < b.PSNR })
+
+	for _, out := range []*FileRecord{bySaving, byPSNR} {
+		if out != nil && !slices.Contains(r.bySaving, out) && !slices.Contains(r.byPSNR, out) {
+			out.SourceThumb, out.OutputThumb = "", ""
+		}
+	}
+}
+
+// insertWorst adds rec to list, which is sorted worst first and holds at
+// most maxThumbnails records, and returns the record that fell off the end.
+func insertWorst(list []*FileRecord, rec *FileRecord, worse func(a, b *FileRecord) bool) ([]*FileRecord, *FileRecord) {
+	i := sort.Search(len(list), func(i int) bool { return worse(rec, list[i]) })
+	list = slices.Insert(list, i, rec)
+	if len(list) <= maxThumbnails {
+		return list, nil
+	}
+
+	out := list[maxThumbnails]
+	list[maxThumbnails] = nil
+	return list[:maxThumbnails], out
+}
+
+var htmlReportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"mb": func(n int64) string { return fmt.Sprintf("%.2f MB", float64(n)/1024/1024) },
+	"kb": func(n int64) string { return fmt.Sprintf("%.1f KB", float64(n)/1024) },
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>avifconv report – {{.Summary.Root}}</title>
+<style>
+body { font: 14px/1.4 system-ui, sans-serif; margin: 2em; color: #222; }
+table { border-collapse: collapse; width: 100%; }
+th, td { padding: 4px 8px; border-bottom: 1px solid #ddd; text-align: left; vertical-align: top; }
+th { cursor: pointer; background: #f4f4f4; position: sticky; top: 0; user-select: none; }
+th.asc::after { content: " ▲"; } th.desc::after { content: " ▼"; }
+td.num { text-align: right; font-variant-numeric: tabular-nums; }
+tr.failed td { color: #a00; }
+.worst { background: #fde2e2; font-weight: bold; }
+.compare { position: relative; display: inline-block; line-height: 0; }
+.compare img { display: block; image-rendering: auto; }
+.compare .after { position: absolute; top: 0; overflow: hidden; border-left: 1px solid #fff; }
+.compare input { width: 100%; margin: 4px 0 0; }
+.legend span { padding: 0 6px; margin-right: 1em; }
+dl { display: grid; grid-template-columns: max-content auto; gap: 2px 1em; }
+dt { font-weight: bold; }
+</style>
+</head>
+<body>
+<h1>avifconv report</h1>
+<dl>
+<dt>Directory</dt><dd>{{.Summary.Root}}</dd>
+<dt>Generated</dt><dd>{{.Generated}}</dd>
+<dt>Options</dt><dd>quality {{.Summary.Options.Quality}}, alpha {{.Summary.Options.QualityAlpha}}, speed {{.Summary.Options.Speed}}, chroma {{.Summary.Options.ChromaSubsampling}}</dd>
+<dt>Files</dt><dd>{{.Summary.Converted}} converted, {{.Summary.Failed}} failed, {{.Summary.Skipped}} skipped, {{.Summary.Resumed}} done before resume, {{.Summary.Duplicates}} duplicates</dd>
+<dt>Size</dt><dd>{{mb .Summary.OriginalSize}} → {{mb .Summary.CompressedSize}} ({{printf "%.1f" .Summary.CompressionRatio}}%)</dd>
+</dl>
+<p class="legend"><span class="worst">highlighted</span> worst savings or lowest PSNR. Comparisons are kept for the {{.Thumbnails}} files with the worst savings and the {{.Thumbnails}} with the lowest PSNR; drag a slider to compare the original (left) with the AVIF (right).</p>
+<table id="files">
+<thead><tr>
+<th data-type="text">File</th><th data-type="text">Status</th><th data-type="num">Dimensions</th>
+<th data-type="num">Original</th><th data-type="num">AVIF</th><th data-type="num">Ratio</th>
+<th data-type="num">Saving</th><th data-type="num">PSNR (dB)</th><th data-type="num">Encode (ms)</th><th>Comparison</th>
+</tr></thead>
+<tbody>
+{{- range .Rows}}
+<tr class="{{.Status}}">
+<td>{{.Name}}{{if .Error}}<br><small>{{.Error}}</small>{{end}}</td>
+<td>{{.Status}}</td>
+<td class="num" data-value="{{.Width}}">{{if .Width}}{{.Width}}×{{.Height}}{{end}}</td>
+<td class="num" data-value="{{.OriginalSize}}">{{kb .OriginalSize}}</td>
+<td class="num" data-value="{{.CompressedSize}}">{{if .CompressedSize}}{{kb .CompressedSize}}{{end}}</td>
+<td class="num" data-value="{{.Ratio}}">{{if .Ratio}}{{printf "%.1f" .Ratio}}%{{end}}</td>
+<td class="num{{if .WorstSaving}} worst{{end}}" data-value="{{.Saving}}">{{if .Ratio}}{{printf "%.1f" .Saving}}%{{end}}</td>
+<td class="num{{if .LowScore}} worst{{end}}" data-value="{{.PSNR}}">{{if .PSNR}}{{printf "%.2f" .PSNR}}{{end}}</td>
+<td class="num" data-value="{{.EncodeMS}}">{{if .EncodeMS}}{{printf "%.0f" .EncodeMS}}{{end}}</td>
+<td>{{if .SourceThumb}}<div class="compare"><img src="{{.SourceThumb}}" alt="original"><div class="after"><img src="{{.OutputThumb}}" alt="AVIF"></div><input type="range" min="0" max="100" value="50" aria-label="compare"></div>{{end}}</td>
+</tr>
+{{- end}}
+</tbody>
+</table>
+<script>
+window.addEventListener("load", function () {
+  document.querySelectorAll(".compare").forEach(function (c) {
+    var before = c.querySelector("img"), after = c.querySelector(".after"),
+        img = after.querySelector("img"), range = c.querySelector("input");
+    var update = function () {
+      var w = before.width, h = before.height, x = w * range.value / 100;
+      img.style.width = w + "px";
+      after.style.height = h + "px";
+      after.style.left = x + "px";
+      after.style.width = (w - x) + "px";
+      img.style.marginLeft = -x + "px";
+    };
+    range.addEventListener("input", update);
+    update();
+  });
+});
+document.querySelectorAll("#files th[data-type]").forEach(function (th, col) {
+  th.addEventListener("click", function () {
+    var tbody = document.querySelector("#files tbody"), asc = !th.classList.contains("asc");
+    document.querySelectorAll("#files th").forEach(function (h) { h.classList.remove("asc", "desc"); });
+    th.classList.add(asc ? "asc" : "desc");
+    var key = function (tr) {
+      var td = tr.children[col];
+      return th.dataset.type === "num" ? parseFloat(td.dataset.value) || 0 : td.textContent.toLowerCase();
+    };
+    Array.from(tbody.rows).sort(function (a, b) {
+      var x = key(a), y = key(b);
+      return (x < y ? -1 : x > y ? 1 : 0) * (asc ? 1 : -1);
+    }).forEach(function (tr) { tbody.appendChild(tr); });
+  });
+});
+</script>
+</body>
+</html>
+`))
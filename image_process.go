@@ -2,14 +2,20 @@ package main
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"image"
 	_ "image/jpeg"
 	_ "image/png"
+	"io"
+	"log/slog"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"avifconv/logger"
@@ -18,11 +24,15 @@ import (
 	_ "golang.org/x/image/webp"
 )
 
-var supportedFormats = map[string]bool{
-	".jpg":  true,
-	".jpeg": true,
-	".png":  true,
-	".webp": true,
+var supportedFormats = map[string]string{
+	".jpg":   "jpeg",
+	".jpeg":  "jpeg",
+	".jpe":   "jpeg",
+	".jfif":  "jpeg",
+	".pjpeg": "jpeg",
+	".pjp":   "jpeg",
+	".png":   "png",
+	".webp":  "webp",
 }
 
 type Processor struct {
@@ -30,8 +40,58 @@ type Processor struct {
 	Console    *logger.Console
 	NumWorkers int
 	QueueSize  int
+	Verify     bool
+	VerifyPSNR float64
+
+	Backup          *Backup
+	BackupRetention int
+	Journal         *Journal
+
+	Checkpoint *Checkpoint
+	Resume     bool
+
+	Root         string
+	OutDir       string
+	Cache        *Cache
+	PruneOutputs bool
+
+	Dedup      DedupMode
+	duplicates map[string]*duplicateSet
+
+	IncludeExtensionless bool
+
+	PreserveMode  bool
+	PreserveTimes bool
+	PreserveOwner bool
+
+	TempDir string
+
+	Memory *memoryBudget
+	Order  JobOrder
+
+	dashboard *logger.Dashboard
+
+	Timeout     time.Duration
+	SlowWarning time.Duration
+
+	Retries      int
+	RetryBackoff time.Duration
+	FailuresFile string
+
+	MaxFailures int
+	FailRatio   float64
+	abort       context.CancelFunc
+
+	Report *Report
+
+	MetricsFile string
+	MetricsAddr string
 }
 
+// failRatioMinFiles is how many files must be processed before --fail-ratio
+// is checked, so one early failure does not abort the batch.
+const failRatioMinFiles = 10
+
 type ProcessStats struct {
 	mu                  sync.Mutex
 	TotalOriginalSize   int64
@@ -40,20 +100,142 @@ type ProcessStats struct {
 	ProcessedFiles      int
 	SuccessfulFiles     int
 	FailedFiles         int
+	FailuresByCategory  map[ErrorCategory]int
+	SkippedFiles        int
+	ResumedFiles        int
+	DuplicateFiles      int
+	DuplicateTimeSaved  time.Duration
+	Elapsed             time.Duration
+	LongestFile         string
+	LongestDuration     time.Duration
+	Aborted             bool
+	EncodeDurations     durationHistogram
+	LastSuccess         time.Time
+
+	failures       []failureRecord
+	recentFailures []string
 }
 
 type workerStatus struct {
 	StartTime   time.Time
 	CurrentFile string
 	Busy        bool
+	Warned      bool
 }
 
 func NewProcessor(cfg *Config, console *logger.Console) *Processor {
+	root, err := filepath.Abs(cfg.InputPath)
+	if err != nil {
+		root = cfg.InputPath
+	}
+	if info, err := os.Stat(root); err == nil && !info.IsDir() {
+		root = filepath.Dir(root)
+	}
+
+	runID := time.Now().Format(backupSetLayout)
+
+	// State files live next to the outputs, so out-dir runs leave the
+	// source tree untouched.
+	stateDir := filepath.Join(root, backupFolderName)
+
+	outDir := cfg.OutDir
+	if outDir != "" {
+		if abs, err := filepath.Abs(outDir); err == nil {
+			outDir = abs
+		}
+		stateDir = filepath.Join(outDir, backupFolderName)
+	}
+
+	journalPath := cfg.JournalPath
+	if journalPath == "" {
+		journalPath = filepath.Join(stateDir, "journal-"+runID+".jsonl")
+	}
+
+	// An in-place run without a backup deletes its originals, so undo could
+	// not restore anything from its journal; only an explicit --journal
+	// keeps one.
+	var journal *Journal
+	if cfg.OutDir != "" || cfg.BackupMode != BackupNone || cfg.JournalPath != "" {
+		journal = NewJournal(journalPath)
+	}
+
+	var cache *Cache
+	if cfg.Incremental {
+		cache = NewCache(filepath.Join(outDir, cacheFileName), root, fmt.Sprintf("%+v", cfg.GetEncodingOptions()))
+	}
+
+	var memory *memoryBudget
+	if cfg.MemoryBudget > 0 {
+		memory = newMemoryBudget(int64(cfg.MemoryBudget) * 1024 * 1024)
+	}
+
+	var dashboard *logger.Dashboard
+	if cfg.Dashboard && !console.Structured && !console.Quiet {
+		if d := console.NewDashboard(); d.Interactive() {
+			dashboard = d
+		}
+	}
+
+	checkpointPath := cfg.CheckpointPath
+	if checkpointPath == "" {
+		checkpointPath = filepath.Join(stateDir, "checkpoint.json")
+	}
+
+	var report *Report
+	if cfg.ReportPath != "" || cfg.HTMLReport != "" {
+		report = NewReport(cfg.ReportPath, cfg.ReportFormat, cfg.HTMLReport, root)
+	}
+
 	return &Processor{
 		Options:    cfg.GetEncodingOptions(),
 		NumWorkers: cfg.Workers,
 		QueueSize:  cfg.QueueSize,
+		Verify:     cfg.Verify,
+		VerifyPSNR: cfg.VerifyPSNR,
 		Console:    console,
+
+		Backup:          NewBackup(cfg.BackupMode, root, cfg.BackupDir, runID),
+		BackupRetention: cfg.BackupRetention,
+		Journal:         journal,
+
+		Checkpoint: NewCheckpoint(checkpointPath, root),
+		Resume:     cfg.Resume,
+
+		Root:         root,
+		OutDir:       outDir,
+		Cache:        cache,
+		PruneOutputs: cfg.PruneOutputs,
+
+		Dedup: cfg.Dedup,
+
+		IncludeExtensionless: cfg.IncludeExtensionless,
+
+		PreserveMode:  cfg.PreserveMode,
+		PreserveTimes: cfg.PreserveTimes,
+		PreserveOwner: cfg.PreserveOwner,
+
+		TempDir: cfg.TempDir,
+
+		Memory: memory,
+		Order:  cfg.Order,
+
+		dashboard: dashboard,
+
+		Timeout:     cfg.Timeout,
+		SlowWarning: cfg.SlowWarning,
+
+		Retries:      cfg.Retries,
+		RetryBackoff: cfg.RetryBackoff,
+		FailuresFile: cfg.FailuresFile,
+
+		MaxFailures: cfg.MaxFailures,
+		FailRatio:   cfg.FailRatio,
+		abort:       func() {},
+
+		Report: report,
+
+		MetricsFile: cfg.MetricsFile,
+		MetricsAddr: cfg.MetricsAddr,
 	}
 }
 
@@ -64,89 +246,466 @@ func (p *Processor) ProcessPath(path string) error {
 	}
 
 	if fileInfo.IsDir() {
-		return p.ProcessDirectory(path)
+		err = p.ProcessDirectory(path)
+	} else {
+		err = p.ProcessSingleFile(path)
+	}
+
+	if berr := p.finishBackup(); err == nil {
+		err = berr
+	}
+
+	if jerr := p.Journal.Close(); err == nil && jerr != nil {
+		err = fmt.Errorf("journal error: %w", jerr)
+	}
+
+	return err
+}
+
+func (p *Processor) finishBackup() error {
+	if p.Backup == nil {
+		return nil
+	}
+
+	if err := p.Backup.Close(); err != nil {
+		return fmt.Errorf("backup archive error: %w", err)
+	}
+
+	if p.BackupRetention > 0 {
+		removed, err := p.Backup.Prune(p.BackupRetention)
+		if err != nil {
+			return fmt.Errorf("backup retention error: %w", err)
+		}
+		if removed > 0 {
+			p.Console.Info("Pruned %d old backup set(s)", removed)
+		}
 	}
 
-	return p.ProcessSingleFile(path)
+	return nil
 }
 
 func (p *Processor) ProcessDirectory(dirPath string) error {
-	p.Console.Info("Processing directory: %s (workers: %d, quality: %d, speed: %d)",
-		dirPath, p.NumWorkers, p.Options.Quality, p.Options.Speed)
+	p.Console.Infow("Processing directory", "path", dirPath, "workers", p.NumWorkers,
+		"quality", p.Options.Quality, "speed", p.Options.Speed)
+
+	if p.Resume {
+		found, err := p.Checkpoint.Load()
+		if err != nil {
+			return fmt.Errorf("resume error: %w", err)
+		}
+		if !found {
+			p.Console.Warn("No checkpoint found at %s, starting a new batch", p.Checkpoint.Path)
+		} else {
+			p.Console.Info("Resuming batch: %d file(s) already done", p.Checkpoint.Completed())
+		}
+	}
+
+	if p.Cache != nil {
+		if err := p.Cache.Load(); err != nil {
+			return fmt.Errorf("incremental cache error: %w", err)
+		}
+	}
+
+	// Start parallel processing. The first interrupt stops handing out new
+	// files; a second one uses the default handler and exits immediately.
+	sigCtx, stopSignals := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stopSignals()
+	go func() {
+		<-sigCtx.Done()
+		stopSignals()
+	}()
+
+	ctx, cancel := context.WithCancel(sigCtx)
+	defer cancel()
+	p.abort = cancel
 
-	filesToProcess, err := p.collectFiles(dirPath)
+	stats := &ProcessStats{}
+
+	started := time.Now()
+
+	stopMetrics, err := p.startMetrics(stats, started)
 	if err != nil {
+		return err
+	}
+
+	if p.needsFileList() {
+		err = p.processCollected(ctx, dirPath, stats)
+	} else {
+		p.Console.Info("Starting batch processing")
+		err = p.processStreaming(ctx, dirPath, stats)
+	}
+	stopMetrics()
+	if err != nil && ctx.Err() == nil {
 		return fmt.Errorf("file collection error: %w", err)
 	}
+	stats.Elapsed = time.Since(started)
+	interrupted := sigCtx.Err() != nil
 
-	totalFiles := len(filesToProcess)
-	if totalFiles == 0 {
-		p.Console.Warn("No files found to process")
-		return nil
+	if stats.SkippedFiles > 0 {
+		p.Console.Info("Skipped %d up-to-date file(s)", stats.SkippedFiles)
 	}
 
-	p.Console.Info("Starting batch processing of %d files", totalFiles)
+	if p.Cache != nil {
+		if err := p.finishCache(); err != nil {
+			p.Console.Warn("Incremental cache not saved: %v", err)
+		}
+	}
 
-	// Start parallel processing
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+	if stats.ProcessedFiles == stats.TotalFiles {
+		err = p.Checkpoint.Remove()
+	} else {
+		err = p.Checkpoint.Flush()
+	}
+	if err != nil {
+		p.Console.Warn("Checkpoint not updated: %v", err)
+	}
+
+	// A run that only skipped up-to-date files still gets its report and
+	// metrics, so the skipped count and last success stay exported.
+	if err := p.finishReport(stats, started, interrupted); err != nil {
+		p.Console.Warn("Report not written: %v", err)
+	}
+	if err := p.writeMetricsFile(stats, started); err != nil {
+		p.Console.Warn("Metrics not written: %v", err)
+	}
+
+	if stats.TotalFiles == 0 {
+		if interrupted {
+			return &BatchError{Interrupted: true}
+		}
+		if stats.SkippedFiles == 0 {
+			p.Console.Warn("No files found to process")
+		}
+		return nil
+	}
 
-	stats := &ProcessStats{TotalFiles: totalFiles}
-	p.processFilesParallel(ctx, filesToProcess, stats)
+	if p.FailuresFile != "" && len(stats.failures) > 0 {
+		if err := writeFailures(p.FailuresFile, stats.failures); err != nil {
+			p.Console.Warn("%v", err)
+		} else {
+			p.Console.Info("Failed files listed in %s", p.FailuresFile)
+		}
+	}
 
 	// Display results
 	p.displayResults(stats)
 
+	switch {
+	case interrupted:
+		p.Console.Warn("Interrupted; run again with --resume to continue")
+	case stats.Aborted:
+		p.Console.Warn("Batch aborted: failure threshold exceeded")
+	}
+
+	if interrupted || stats.Aborted || stats.FailedFiles > 0 {
+		return &BatchError{
+			Failed:      stats.FailedFiles,
+			Succeeded:   stats.SuccessfulFiles + stats.DuplicateFiles,
+			Aborted:     stats.Aborted,
+			Interrupted: interrupted,
+		}
+	}
+
 	return nil
 }
 
-func (p *Processor) collectFiles(dirPath string) ([]string, error) {
+// failureThresholdExceeded reports whether --max-failures or --fail-ratio
+// has been crossed. Callers hold stats.mu.
+func (p *Processor) failureThresholdExceeded(stats *ProcessStats) bool {
+	if p.MaxFailures > 0 && stats.FailedFiles >= p.MaxFailures {
+		return true
+	}
+	if p.FailRatio > 0 && stats.ProcessedFiles >= failRatioMinFiles &&
+		float64(stats.FailedFiles)/float64(stats.ProcessedFiles) > p.FailRatio {
+		return true
+	}
+	return false
+}
+
+// needsFileList reports whether the batch has to be known in full before the
+// first conversion starts. Otherwise discovery and encoding run concurrently.
+func (p *Processor) needsFileList() bool {
+	return p.Dedup != DedupOff || p.Order != OrderWalk
+}
+
+// processStreaming walks dirPath and feeds candidates to the workers as they
+// are found. The progress bar total grows until the walk is finished.
+func (p *Processor) processStreaming(ctx context.Context, dirPath string, stats *ProcessStats) error {
+	jobs := make(chan string, p.QueueSize)
+
+	bar := p.Console.NewProgressBar(0, "Converting images")
+	bar.SetDiscovering(true)
+
+	// The walker is joined before walkErr is read, so it never touches
+	// stats, the report or the bar after the batch is finalized.
+	var walkErr error
+	walkDone := make(chan struct{})
+	go func() {
+		defer close(walkDone)
+		defer close(jobs)
+
+		walkErr = p.walkCandidates(dirPath, stats, func(path string) error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			if !p.shouldProcess(path, stats) {
+				return nil
+			}
+
+			stats.mu.Lock()
+			stats.TotalFiles++
+			stats.mu.Unlock()
+			bar.AddTotal(1)
+
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case jobs <- path:
+				return nil
+			}
+		})
+
+		bar.SetDiscovering(false)
+	}()
+
+	p.runWorkers(ctx, jobs, stats, bar)
+	<-walkDone
+
+	return walkErr
+}
+
+// processCollected gathers the whole batch first, for features that need to
+// see every file before choosing what to encode.
+func (p *Processor) processCollected(ctx context.Context, dirPath string, stats *ProcessStats) error {
+	filesToProcess, err := p.collectFiles(dirPath, stats)
+	if err != nil {
+		return err
+	}
+
+	pending := filesToProcess[:0]
+	for _, f := range filesToProcess {
+		if p.shouldProcess(f, stats) {
+			pending = append(pending, f)
+		}
+	}
+	filesToProcess = pending
+
+	if p.Dedup != DedupOff && len(filesToProcess) > 1 {
+		filesToProcess, p.duplicates, err = p.groupDuplicates(filesToProcess)
+		if err != nil {
+			return fmt.Errorf("deduplication error: %w", err)
+		}
+		if dups := p.duplicateCount(); dups > 0 {
+			p.Console.Info("Found %d duplicate file(s) of %d unique image(s)", dups, len(p.duplicates))
+		}
+	}
+
+	p.orderJobs(filesToProcess)
+
+	if len(filesToProcess) == 0 {
+		return nil
+	}
+
+	// Duplicates are part of the batch even though only their primary is
+	// encoded, so counts and the progress bar include them.
+	total := len(filesToProcess) + p.duplicateCount()
+
+	stats.mu.Lock()
+	stats.TotalFiles += total
+	stats.mu.Unlock()
+
+	p.Console.Infow("Starting batch processing", "files", total)
+	p.processFilesParallel(ctx, filesToProcess, total, stats)
+
+	return nil
+}
+
+// shouldProcess filters out files that are up to date in the incremental
+// cache or already converted according to the resumed checkpoint.
+func (p *Processor) shouldProcess(path string, stats *ProcessStats) bool {
+	if p.Cache != nil && p.Cache.UpToDate(path, p.outputPath(path)) {
+		stats.mu.Lock()
+		stats.SkippedFiles++
+		stats.mu.Unlock()
+
+		rec := p.newFileRecord(path, 0)
+		rec.Output = p.outputPath(path)
+		rec.OriginalSize = fileSize(path)
+		rec.Status = StatusSkipped
+		p.addRecord(rec)
+		return false
+	}
+
+	if p.Resume && p.Checkpoint.IsDone(path) {
+		stats.mu.Lock()
+		stats.ResumedFiles++
+		stats.mu.Unlock()
+
+		rec := p.newFileRecord(path, 0)
+		rec.Output = p.outputPath(path)
+		rec.OriginalSize = fileSize(path)
+		rec.Status = StatusResumed
+		p.addRecord(rec)
+		return false
+	}
+
+	return true
+}
+
+func (p *Processor) collectFiles(dirPath string, stats *ProcessStats) ([]string, error) {
 	var filesToProcess []string
 
-	err := filepath.Walk(dirPath, func(path string, info os.FileInfo, err error) error {
+	err := p.walkCandidates(dirPath, stats, func(path string) error {
+		filesToProcess = append(filesToProcess, path)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return filesToProcess, nil
+}
+
+// walkCandidates calls fn for every convertible image below dirPath, skipping
+// avifconv's own state and output directories. Entries that cannot be read
+// are counted as failures and the walk goes on, so what was converted still
+// reaches the cache, checkpoint and report.
+func (p *Processor) walkCandidates(dirPath string, stats *ProcessStats, fn func(path string) error) error {
+	staleTemps := 0
+
+	err := filepath.WalkDir(dirPath, func(path string, d os.DirEntry, err error) error {
 		if err != nil {
-			return err
+			p.recordWalkFailure(path, fmt.Errorf("error while exploring directory: %w", err), stats)
+			if d != nil && d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
 		}
 
-		if info.IsDir() {
+		if d.IsDir() {
+			if d.Name() == backupFolderName || p.isBackupDir(path) || p.isOutDir(path) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if isTempFile(d.Name()) {
+			if p.Resume && os.Remove(path) == nil {
+				staleTemps++
+			}
 			return nil
 		}
 
 		ext := strings.ToLower(filepath.Ext(path))
-		if supportedFormats[ext] {
-			filesToProcess = append(filesToProcess, path)
+		extFormat, known := supportedFormats[ext]
+		if !known && !(ext == "" && p.IncludeExtensionless) {
+			return nil
 		}
 
-		return nil
+		ok, note, err := checkCandidate(path, extFormat)
+		if err != nil {
+			p.recordWalkFailure(path, err, stats)
+			return nil
+		}
+		if !ok {
+			if known {
+				p.Console.Warnw("Skipping file", "file", path, "reason", note)
+			}
+			return nil
+		}
+		if note != "" {
+			p.Console.Warnw("Content does not match extension", "file", path, "reason", note)
+		}
+
+		return fn(path)
 	})
 
+	if staleTemps > 0 {
+		p.Console.Info("Removed %d stale temporary file(s) from a previous run", staleTemps)
+	}
+
 	if err != nil {
-		return nil, fmt.Errorf("error while exploring directory: %w", err)
+		return fmt.Errorf("error while exploring directory: %w", err)
 	}
 
-	return filesToProcess, nil
+	return nil
 }
 
-func (p *Processor) processFilesParallel(ctx context.Context, files []string, stats *ProcessStats) {
-	queueSize := p.QueueSize
-	if queueSize > len(files) {
-		queueSize = len(files)
+// recordWalkFailure counts a path that fails before conversion, such as an
+// unreadable directory or a broken image, as a failed file.
+func (p *Processor) recordWalkFailure(path string, err error, stats *ProcessStats) {
+	stats.mu.Lock()
+	stats.TotalFiles++
+	stats.ProcessedFiles++
+	stats.FailedFiles++
+	stats.recordFailure(path, err)
+	stats.mu.Unlock()
+
+	rec := p.newFileRecord(path, 0)
+	rec.setResult(fileSize(path), 0, err)
+	p.addRecord(rec)
+
+	p.Console.Errorw("Error processing file", "file", path, "category", classifyError(err), "error", err)
+}
+
+// fileSize returns the size of the regular file at path, or 0 if it cannot
+// be determined.
+func fileSize(path string) int64 {
+	info, err := os.Stat(path)
+	if err != nil || !info.Mode().IsRegular() {
+		return 0
 	}
+	return info.Size()
+}
 
-	jobs := make(chan string, queueSize)
+// isBackupDir reports whether path is the backup directory or inside it, so
+// backed-up originals are never converted again.
+func (p *Processor) isBackupDir(path string) bool {
+	if p.Backup == nil {
+		return false
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+	return abs == p.Backup.Dir || strings.HasPrefix(abs, p.Backup.Dir+string(filepath.Separator))
+}
 
-	workerStatuses := make([]workerStatus, p.NumWorkers)
+func (p *Processor) isOutDir(path string) bool {
+	if p.OutDir == "" {
+		return false
+	}
+	abs, err := filepath.Abs(path)
+	return err == nil && abs == p.OutDir
+}
 
-	bar := p.Console.NewProgressBar(int64(len(files)), "Converting images")
+func (p *Processor) finishCache() error {
+	if p.PruneOutputs {
+		removed, err := p.Cache.PruneOrphans()
+		if err != nil {
+			return err
+		}
+		if removed > 0 {
+			p.Console.Info("Pruned %d output(s) whose source was deleted", removed)
+		}
+	}
 
-	var wg sync.WaitGroup
+	return p.Cache.Save()
+}
 
-	for w := 0; w < p.NumWorkers; w++ {
-		wg.Add(1)
-		go p.worker(ctx, w, jobs, stats, &workerStatuses[w], &wg, bar)
+func (p *Processor) processFilesParallel(ctx context.Context, files []string, total int, stats *ProcessStats) {
+	queueSize := p.QueueSize
+	if queueSize > len(files) {
+		queueSize = len(files)
 	}
 
+	jobs := make(chan string, queueSize)
+
+	bar := p.Console.NewProgressBar(int64(total), "Converting images")
+
 	go func() {
+		defer close(jobs)
 		for _, file := range files {
 			select {
 			case <-ctx.Done():
@@ -154,10 +713,31 @@ func (p *Processor) processFilesParallel(ctx context.Context, files []string, st
 			case jobs <- file:
 			}
 		}
-		close(jobs)
 	}()
 
+	p.runWorkers(ctx, jobs, stats, bar)
+}
+
+// runWorkers converts every file received on jobs until the channel is closed.
+func (p *Processor) runWorkers(ctx context.Context, jobs <-chan string, stats *ProcessStats, bar *logger.ProgressBar) {
+	workerStatuses := make([]workerStatus, p.NumWorkers)
+
+	stopDashboard := func() {}
+	if p.dashboard != nil {
+		stopDashboard = p.startDashboard(workerStatuses, stats, bar)
+	}
+	stopWatchdog := p.startWatchdog(workerStatuses, stats)
+
+	var wg sync.WaitGroup
+
+	for w := 0; w < p.NumWorkers; w++ {
+		wg.Add(1)
+		go p.worker(ctx, w, jobs, stats, &workerStatuses[w], &wg, bar)
+	}
+
 	wg.Wait()
+	stopWatchdog()
+	stopDashboard()
 	bar.Complete()
 }
 
@@ -170,13 +750,20 @@ func (p *Processor) worker(ctx context.Context, id int, jobs <-chan string, stat
 		case <-ctx.Done():
 			return
 		default:
+			start := time.Now()
+
 			stats.mu.Lock()
 			status.Busy = true
 			status.CurrentFile = filePath
-			status.StartTime = time.Now()
+			status.StartTime = start
+			status.Warned = false
 			stats.mu.Unlock()
 
-			origSize, compSize, err := p.processFileWithStats(filePath)
+			// A file that has been started is finished even when the batch
+			// stops, so an interrupt never abandons a child encode or a file
+			// waiting for memory; only new files are no longer taken.
+			rec := p.newFileRecord(filePath, id+1)
+			origSize, compSize, err := p.processWithRetry(context.WithoutCancel(ctx), filePath, rec)
 
 			stats.mu.Lock()
 			stats.ProcessedFiles++
@@ -185,32 +772,160 @@ func (p *Processor) worker(ctx context.Context, id int, jobs <-chan string, stat
 			status.Busy = false
 			status.CurrentFile = ""
 
+			if took := time.Since(start); took > stats.LongestDuration {
+				stats.LongestDuration = took
+				stats.LongestFile = filePath
+			}
+
 			if err != nil {
 				stats.FailedFiles++
-				p.Console.Error("Worker %d: Error processing %s: %v (%.1f%% complete)",
-					id+1, filepath.Base(filePath), err, progress)
+				stats.recordFailure(filePath, err)
+				p.Console.Errorw("Error processing file", "file", filePath, "worker", id+1,
+					"category", classifyError(err), "error", err, "progress", fmt.Sprintf("%.1f%%", progress))
 			} else {
 				stats.SuccessfulFiles++
 				stats.TotalOriginalSize += origSize
 				stats.TotalCompressedSize += compSize
+				stats.EncodeDurations.observe(rec.encodeDuration())
+				stats.LastSuccess = time.Now()
 			}
 
 			bar.Increment(1)
 
+			if err != nil && !stats.Aborted && p.failureThresholdExceeded(stats) {
+				stats.Aborted = true
+				p.abort()
+			}
+
 			stats.mu.Unlock()
+
+			rec.setResult(origSize, compSize, err)
+			p.addRecord(rec)
+			if err == nil {
+				p.logConverted(rec, time.Since(start))
+			}
+
+			if cerr := p.Checkpoint.Mark(filePath, err == nil); cerr != nil {
+				p.Console.Warn("Checkpoint not updated: %v", cerr)
+			}
+
+			if set := p.duplicates[filePath]; set != nil {
+				p.processDuplicates(filePath, set, err, time.Since(start), stats, bar)
+			}
 		}
 	}
 }
 
+// logConverted prints the per-file line shown with -v, followed by the
+// details shown with -vv.
+func (p *Processor) logConverted(rec *FileRecord, took time.Duration) {
+	p.Console.Verbosew("Converted to AVIF", "file", rec.Source, "output", rec.Output,
+		"original_size", rec.OriginalSize, "compressed_size", rec.CompressedSize,
+		"ratio", fmt.Sprintf("%.1f%%", compressionRatio(rec.OriginalSize, rec.CompressedSize)),
+		"duration", took.Round(time.Millisecond))
+	p.logDetails(rec, took)
+}
+
+// logDetails prints the phase timings and encoder options of a conversion at
+// debug level. Whatever is not decoding or encoding is verification,
+// metadata and writing the output.
+func (p *Processor) logDetails(rec *FileRecord, took time.Duration) {
+	if !p.Console.Enabled(slog.LevelDebug) {
+		return
+	}
+
+	decode := time.Duration(rec.DecodeMS * float64(time.Millisecond))
+	encode := rec.encodeDuration()
+	p.Console.Debugw("Conversion details", "file", rec.Source,
+		"width", rec.Width, "height", rec.Height,
+		"decode", decode.Round(time.Millisecond), "encode", encode.Round(time.Millisecond),
+		"finish", (took - decode - encode).Round(time.Millisecond),
+		"quality", rec.Options.Quality, "quality_alpha", rec.Options.QualityAlpha,
+		"speed", rec.Options.Speed, "chroma", rec.Options.ChromaSubsampling)
+}
+
+// compressionRatio returns compSize as a percentage of origSize.
+func compressionRatio(origSize, compSize int64) float64 {
+	if origSize <= 0 {
+		return 0
+	}
+	return float64(compSize) / float64(origSize) * 100
+}
+
+// processWithinBudget reserves the file's estimated memory before converting
+// it, so the decoded pixels in flight stay within the configured budget.
+func (p *Processor) processWithinBudget(ctx context.Context, filePath string, rec *FileRecord) (int64, int64, error) {
+	if p.Memory == nil {
+		return p.processFileWithStats(ctx, filePath, rec)
+	}
+
+	// Stat first, so a file whose header cannot be read is still reported
+	// with its size.
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to get file info: %w", err)
+	}
+
+	need, err := estimateMemory(filePath)
+	if err != nil {
+		return info.Size(), 0, err
+	}
+	if need > p.Memory.size {
+		p.Console.Warnw("File needs more memory than the budget; converting it alone",
+			"file", filePath, "need_mb", need/1024/1024)
+	}
+
+	reserved, err := p.Memory.Acquire(ctx, need)
+	if err != nil {
+		return info.Size(), 0, err
+	}
+	defer p.Memory.Release(reserved)
+
+	return p.processFileWithStats(ctx, filePath, rec)
+}
+
 func (p *Processor) displayResults(stats *ProcessStats) {
-	var overallCompressionRatio float64
-	if stats.TotalOriginalSize > 0 {
-		overallCompressionRatio = float64(stats.TotalCompressedSize) / float64(stats.TotalOriginalSize) * 100
+	if p.Console.Quiet {
+		return
+	}
+
+	overallCompressionRatio := compressionRatio(stats.TotalOriginalSize, stats.TotalCompressedSize)
+
+	if p.Console.Structured {
+		args := []any{
+			"total", stats.TotalFiles, "converted", stats.SuccessfulFiles, "failed", stats.FailedFiles,
+			"skipped", stats.SkippedFiles, "resumed", stats.ResumedFiles, "duplicates", stats.DuplicateFiles,
+			"original_size", stats.TotalOriginalSize, "compressed_size", stats.TotalCompressedSize,
+			"ratio", fmt.Sprintf("%.1f%%", overallCompressionRatio), "duration", stats.Elapsed,
+		}
+		if len(stats.FailuresByCategory) > 0 {
+			args = append(args, "failures_by_category", stats.FailuresByCategory)
+		}
+		if stats.LongestFile != "" {
+			args = append(args, "longest_file", stats.LongestFile, "longest_duration", stats.LongestDuration)
+		}
+		p.Console.Infow("Processing summary", args...)
+		return
 	}
 
 	table := p.Console.NewTable([]string{"Metric", "Value"})
-	table.AddRow("Processed files", fmt.Sprintf("%d/%d", stats.SuccessfulFiles, stats.TotalFiles))
+	table.AddRow("Processed files", fmt.Sprintf("%d/%d", stats.SuccessfulFiles+stats.DuplicateFiles, stats.TotalFiles))
 	table.AddRow("Failed files", fmt.Sprintf("%d", stats.FailedFiles))
+	for _, category := range errorCategories {
+		if n := stats.FailuresByCategory[category]; n > 0 {
+			table.AddRow("  "+string(category), fmt.Sprintf("%d", n))
+		}
+	}
+	if stats.SkippedFiles > 0 {
+		table.AddRow("Skipped (up to date)", fmt.Sprintf("%d", stats.SkippedFiles))
+	}
+	if stats.ResumedFiles > 0 {
+		table.AddRow("Done before resume", fmt.Sprintf("%d", stats.ResumedFiles))
+	}
+	if stats.DuplicateFiles > 0 {
+		table.AddRow("Duplicates reused", fmt.Sprintf("%d", stats.DuplicateFiles))
+		table.AddRow("Encode time saved", stats.DuplicateTimeSaved.Round(time.Millisecond).String())
+	}
 	table.AddRow("Original size", fmt.Sprintf("%.2f MB", float64(stats.TotalOriginalSize)/1024/1024))
 	table.AddRow("Compressed size", fmt.Sprintf("%.2f MB", float64(stats.TotalCompressedSize)/1024/1024))
 	table.AddRow("Compression ratio", fmt.Sprintf("%.1f%%", overallCompressionRatio))
@@ -220,11 +935,17 @@ func (p *Processor) displayResults(stats *ProcessStats) {
 		table.AddRow("Space saved", fmt.Sprintf("%.2f MB", float64(savedSpace)/1024/1024))
 	}
 
+	table.AddRow("Elapsed time", stats.Elapsed.Round(time.Millisecond).String())
+	if stats.LongestFile != "" {
+		table.AddRow("Critical path", fmt.Sprintf("%s (%s)",
+			stats.LongestDuration.Round(time.Millisecond), filepath.Base(stats.LongestFile)))
+	}
+
 	p.Console.Info("\nProcessing Summary:")
 	table.Print()
 }
 
-func (p *Processor) processFileWithStats(filePath string) (int64, int64, error) {
+func (p *Processor) processFileWithStats(ctx context.Context, filePath string, rec *FileRecord) (int64, int64, error) {
 	fileInfo, err := os.Stat(filePath)
 	if err != nil {
 		return 0, 0, fmt.Errorf("failed to get file info: %w", err)
@@ -237,12 +958,30 @@ func (p *Processor) processFileWithStats(filePath string) (int64, int64, error)
 	}
 	defer f.Close()
 
-	img, _, err := image.Decode(f)
+	decodeStart := time.Now()
+	sourceHash := sha256.New()
+	img, _, err := image.Decode(io.TeeReader(f, sourceHash))
 	if err != nil {
-		return originalSize, 0, fmt.Errorf("error decoding image: %w", err)
+		return originalSize, 0, decodeFailure(fmt.Errorf("error decoding image: %w", err))
+	}
+	if _, err = io.Copy(sourceHash, f); err != nil {
+		return originalSize, 0, fmt.Errorf("error reading file: %w", err)
+	}
+	rec.DecodeMS = millis(time.Since(decodeStart))
+	rec.Width, rec.Height = img.Bounds().Dx(), img.Bounds().Dy()
+
+	outputPath := p.outputPath(filePath)
+	rec.Output = outputPath
+	if err = os.MkdirAll(filepath.Dir(outputPath), 0o755); err != nil {
+		return originalSize, 0, fmt.Errorf("error creating output directory: %w", err)
 	}
 
-	tempFile, err := os.CreateTemp(filepath.Dir(filePath), "*.avif")
+	tempDir := filepath.Dir(outputPath)
+	if p.TempDir != "" {
+		tempDir = p.TempDir
+	}
+
+	tempFile, err := os.CreateTemp(tempDir, tempFilePrefix+"*.avif")
 	if err != nil {
 		return originalSize, 0, fmt.Errorf("error creating temporary file: %w", err)
 	}
@@ -260,13 +999,46 @@ func (p *Processor) processFileWithStats(filePath string) (int64, int64, error)
 		}
 	}()
 
-	err = avif.Encode(tempFile, img, p.Options)
-	if err != nil {
-		return originalSize, 0, fmt.Errorf("error encoding to AVIF: %w", err)
+	encodeStart := time.Now()
+	outputHash := sha256.New()
+	if p.Timeout > 0 {
+		err = p.encodeInSubprocess(ctx, filePath, io.MultiWriter(tempFile, outputHash))
+		if err != nil {
+			return originalSize, 0, err
+		}
+	} else {
+		err = avif.Encode(io.MultiWriter(tempFile, outputHash), img, p.Options)
+		if err != nil {
+			return originalSize, 0, categorized(CategoryEncode, fmt.Errorf("error encoding to AVIF: %w", err))
+		}
 	}
+	rec.EncodeMS = millis(time.Since(encodeStart))
 
-	tempFile.Close()
+	if err = tempFile.Sync(); err != nil {
+		return originalSize, 0, fmt.Errorf("error syncing temporary file: %w", err)
+	}
+	err = tempFile.Close()
 	tempFileClosed = true
+	if err != nil {
+		return originalSize, 0, fmt.Errorf("error closing temporary file: %w", err)
+	}
+
+	var decoded image.Image
+	if p.Verify {
+		if decoded, err = verifyOutput(tempPath, img, p.VerifyPSNR); err != nil {
+			return originalSize, 0, err
+		}
+	}
+
+	if p.Report.WantsComparison() {
+		if cerr := p.captureComparison(tempPath, img, decoded, rec); cerr != nil {
+			p.Console.Warnw("No comparison for report", "file", filePath, "error", cerr)
+		}
+	}
+
+	if err = p.applyMetadata(tempPath, fileInfo); err != nil {
+		return originalSize, 0, err
+	}
 
 	compressedFileInfo, err := os.Stat(tempPath)
 	if err != nil {
@@ -274,11 +1046,11 @@ func (p *Processor) processFileWithStats(filePath string) (int64, int64, error)
 	}
 	compressedSize := compressedFileInfo.Size()
 
-	outputPath := strings.TrimSuffix(filePath, filepath.Ext(filePath)) + ".avif"
-
-	err = os.Remove(filePath)
+	// The output must be durable in its final place before the source goes
+	// away, so a crash at any point leaves at least one complete file.
+	tempPath, err = stageNextTo(tempPath, outputPath, fileInfo, p.applyMetadata)
 	if err != nil {
-		return originalSize, compressedSize, fmt.Errorf("error deleting original file: %w", err)
+		return originalSize, 0, err
 	}
 
 	err = os.Rename(tempPath, outputPath)
@@ -286,30 +1058,143 @@ func (p *Processor) processFileWithStats(filePath string) (int64, int64, error)
 		return originalSize, compressedSize, fmt.Errorf("error renaming file: %w", err)
 	}
 
+	if err = syncDir(filepath.Dir(outputPath)); err != nil {
+		return originalSize, compressedSize, fmt.Errorf("error syncing output directory: %w", err)
+	}
+
+	var backupLocation *BackupLocation
+	if p.OutDir == "" {
+		backupLocation, err = p.removeOriginal(filePath)
+		if err != nil {
+			return originalSize, compressedSize, err
+		}
+	}
+
+	sourceDigest := hex.EncodeToString(sourceHash.Sum(nil))
+	if p.Cache != nil {
+		p.Cache.Update(filePath, sourceDigest, fileInfo, outputPath, compressedSize)
+	}
+
+	p.recordJournal(JournalEntry{
+		Time:       time.Now(),
+		Source:     filePath,
+		Output:     outputPath,
+		SourceHash: sourceDigest,
+		OutputHash: hex.EncodeToString(outputHash.Sum(nil)),
+		SourceSize: originalSize,
+		OutputSize: compressedSize,
+		Backup:     backupLocation,
+	})
+
 	return originalSize, compressedSize, nil
 }
 
+// outputPath returns where the AVIF for filePath is written: next to the
+// source, or at the same relative path below OutDir.
+func (p *Processor) outputPath(filePath string) string {
+	name := strings.TrimSuffix(filePath, filepath.Ext(filePath)) + ".avif"
+	if p.OutDir == "" {
+		return name
+	}
+
+	if abs, err := filepath.Abs(name); err == nil {
+		name = abs
+	}
+	rel, err := filepath.Rel(p.Root, name)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		rel = filepath.Base(name)
+	}
+
+	return filepath.Join(p.OutDir, rel)
+}
+
+func (p *Processor) removeOriginal(filePath string) (*BackupLocation, error) {
+	if p.Backup == nil {
+		if err := os.Remove(filePath); err != nil {
+			return nil, fmt.Errorf("error deleting original file: %w", err)
+		}
+		return nil, nil
+	}
+
+	loc, err := p.Backup.Store(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("error backing up original file: %w", err)
+	}
+	return loc, nil
+}
+
+func (p *Processor) recordJournal(entry JournalEntry) {
+	if p.Journal == nil {
+		return
+	}
+
+	if abs, err := filepath.Abs(entry.Source); err == nil {
+		entry.Source = abs
+	}
+	if abs, err := filepath.Abs(entry.Output); err == nil {
+		entry.Output = abs
+	}
+
+	if err := p.Journal.Record(entry); err != nil {
+		p.Console.Warnw("Journal entry not written", "file", entry.Source, "error", err)
+	}
+}
+
 func (p *Processor) ProcessSingleFile(filePath string) error {
-	p.Console.Info("Processing file: %s", filePath)
+	p.Console.Infow("Processing file", "file", filePath)
+
+	ok, note, err := checkCandidate(filePath, supportedFormats[strings.ToLower(filepath.Ext(filePath))])
+	if err == nil && !ok {
+		return fmt.Errorf("cannot convert %s: %s", filePath, note)
+	}
+	if note != "" {
+		p.Console.Warnw("Content does not match extension", "file", filePath, "reason", note)
+	}
 
 	timer := p.Console.StartTimer("File conversion")
+	started := time.Now()
+
+	// A file that cannot be read or is not an image fails like a conversion,
+	// so it still reaches the report and metrics.
+	rec := p.newFileRecord(filePath, 1)
+	var origSize, compSize int64
+	if err == nil {
+		origSize, compSize, err = p.processWithRetry(context.Background(), filePath, rec)
+	} else {
+		origSize = fileSize(filePath)
+	}
+	rec.setResult(origSize, compSize, err)
+	p.addRecord(rec)
+
+	stats := &ProcessStats{TotalFiles: 1, ProcessedFiles: 1, Elapsed: time.Since(started)}
+	if err != nil {
+		stats.FailedFiles = 1
+		stats.recordFailure(filePath, err)
+	} else {
+		stats.SuccessfulFiles = 1
+		stats.TotalOriginalSize = origSize
+		stats.TotalCompressedSize = compSize
+		stats.EncodeDurations.observe(rec.encodeDuration())
+		stats.LastSuccess = time.Now()
+	}
+	if rerr := p.finishReport(stats, started, false); rerr != nil {
+		p.Console.Warn("Report not written: %v", rerr)
+	}
+	if merr := p.writeMetricsFile(stats, started); merr != nil {
+		p.Console.Warn("Metrics not written: %v", merr)
+	}
 
-	origSize, compSize, err := p.processFileWithStats(filePath)
 	if err != nil {
-		p.Console.Error("Processing failed: %v", err)
+		p.Console.Errorw("Processing failed", "file", filePath, "category", classifyError(err), "error", err)
 		return fmt.Errorf("file processing error: %w", err)
 	}
 
 	duration := timer.End()
 
-	var compressionRatio float64
-	if origSize > 0 {
-		compressionRatio = float64(compSize) / float64(origSize) * 100
-	}
-
-	p.Console.Success("Successfully converted to AVIF: %s", filePath)
-	p.Console.Info("Compression ratio: %.1f%% (%d KB → %d KB) in %v",
-		compressionRatio, origSize/1024, compSize/1024, duration)
+	p.Console.Successw("Converted to AVIF", "file", filePath, "output", rec.Output,
+		"original_size", origSize, "compressed_size", compSize,
+		"ratio", fmt.Sprintf("%.1f%%", compressionRatio(origSize, compSize)), "duration", duration)
+	p.logDetails(rec, duration)
 
 	return nil
 }
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"avifconv/logger"
+)
+
+type JournalEntry struct {
+	Time       time.Time       `json:"time"`
+	Source     string          `json:"source"`
+	Output     string          `json:"output"`
+	SourceHash string          `json:"source_sha256"`
+	OutputHash string          `json:"output_sha256"`
+	SourceSize int64           `json:"source_size"`
+	OutputSize int64           `json:"output_size"`
+	Backup     *BackupLocation `json:"backup,omitempty"`
+}
+
+// Journal appends one JSON line per converted file so a run can be undone.
+type Journal struct {
+	Path string
+
+	mu   sync.Mutex
+	file *os.File
+}
+
+func NewJournal(path string) *Journal {
+	return &Journal{Path: path}
+}
+
+func (j *Journal) Record(entry JournalEntry) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.file == nil {
+		if err := os.MkdirAll(filepath.Dir(j.Path), 0o755); err != nil {
+			return fmt.Errorf("error creating journal directory: %w", err)
+		}
+		f, err := os.OpenFile(j.Path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+		if err != nil {
+			return fmt.Errorf("error opening journal: %w", err)
+		}
+		j.file = f
+	}
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("error encoding journal entry: %w", err)
+	}
+
+	if _, err := j.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("error writing journal: %w", err)
+	}
+
+	return nil
+}
+
+func (j *Journal) Close() error {
+	if j == nil {
+		return nil
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.file == nil {
+		return nil
+	}
+
+	err := j.file.Close()
+	j.file = nil
+	return err
+}
+
+func readJournal(path string) ([]JournalEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening journal: %w", err)
+	}
+	defer f.Close()
+
+	var entries []JournalEntry
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+
+		var e JournalEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return nil, fmt.Errorf("journal line %d: %w", line, err)
+		}
+		entries = append(entries, e)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading journal: %w", err)
+	}
+
+	return entries, nil
+}
+
+// undoJournal restores the originals recorded in a journal and removes the
+// AVIF files the run generated. Entries whose files changed since the run are
+// skipped unless force is set.
+func undoJournal(console *logger.Console, path string, force bool) error {
+	entries, err := readJournal(path)
+	if err != nil {
+		return err
+	}
+
+	restored, skipped := 0, 0
+	for i := len(entries) - 1; i >= 0; i-- {
+		if err := undoEntry(entries[i], force); err != nil {
+			console.Warn("Skipped %s: %v", entries[i].Source, err)
+			skipped++
+			continue
+		}
+		restored++
+	}
+
+	console.Info("Restored %d file(s), skipped %d", restored, skipped)
+	if skipped > 0 {
+		return fmt.Errorf("%d file(s) could not be restored", skipped)
+	}
+
+	return nil
+}
+
+func undoEntry(e JournalEntry, force bool) error {
+	outputExists := true
+	outHash, err := hashFile(e.Output)
+	if errors.Is(err, os.ErrNotExist) {
+		outputExists = false
+	} else if err != nil {
+		return fmt.Errorf("cannot read output: %w", err)
+	} else if outHash != e.OutputHash && !force {
+		return fmt.Errorf("output %s was modified since the run", e.Output)
+	}
+
+	if srcHash, err := hashFile(e.Source); err == nil {
+		if srcHash != e.SourceHash {
+			return fmt.Errorf("source path is occupied by a different file")
+		}
+	} else if errors.Is(err, os.ErrNotExist) {
+		if e.Backup == nil {
+			return fmt.Errorf("original was deleted without a backup")
+		}
+		if err := restoreBackup(e.Backup, e.Source, e.SourceHash, force); err != nil {
+			return err
+		}
+	} else {
+		return fmt.Errorf("cannot read source path: %w", err)
+	}
+
+	if outputExists {
+		if err := os.Remove(e.Output); err != nil {
+			return fmt.Errorf("error removing output: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func restoreBackup(loc *BackupLocation, dst, wantHash string, force bool) error {
+	// A run that did not finish leaves its originals in the staging folder
+	// instead of the archive.
+	if loc.Entry != "" {
+		staged := filepath.Join(loc.Path+backupStagingSuffix, filepath.FromSlash(loc.Entry))
+		if _, err := os.Stat(staged); err == nil {
+			loc = &BackupLocation{Path: staged}
+		}
+	}
+
+	if loc.Entry == "" {
+		hash, err := hashFile(loc.Path)
+		if err != nil {
+			return fmt.Errorf("cannot read backup: %w", err)
+		}
+		if hash != wantHash && !force {
+			return fmt.Errorf("backup %s does not match the recorded hash", loc.Path)
+		}
+		return moveFile(loc.Path, dst)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(dst), tempFilePrefix+"restore-*")
+	if err != nil {
+		return fmt.Errorf("error creating restore file: %w", err)
+	}
+	tmpPath := tmp.Name()
+
+	h := sha256.New()
+	info, err := extractArchiveEntry(loc, io.MultiWriter(tmp, h))
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil && hex.EncodeToString(h.Sum(nil)) != wantHash && !force {
+		err = fmt.Errorf("archived copy of %s does not match the recorded hash", loc.Entry)
+	}
+	if err == nil {
+		err = os.Chmod(tmpPath, info.Mode().Perm())
+	}
+	if err == nil {
+		err = os.Chtimes(tmpPath, info.ModTime(), info.ModTime())
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, dst)
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	return nil
+}
+
+// extractArchiveEntry copies loc's entry to w and returns the original's
+// file info as stored in the archive.
+func extractArchiveEntry(loc *BackupLocation, w io.Writer) (os.FileInfo, error) {
+	if strings.HasSuffix(loc.Path, ".zip") {
+		zr, err := zip.OpenReader(loc.Path)
+		if err != nil {
+			return nil, fmt.Errorf("error opening backup archive: %w", err)
+		}
+		defer zr.Close()
+
+		for _, f := range zr.File {
+			if f.Name != loc.Entry {
+				continue
+			}
+			rc, err := f.Open()
+			if err != nil {
+				return nil, fmt.Errorf("error reading %s from archive: %w", loc.Entry, err)
+			}
+			defer rc.Close()
+			_, err = io.Copy(w, rc)
+			return f.FileInfo(), err
+		}
+		return nil, fmt.Errorf("%s not found in %s", loc.Entry, loc.Path)
+	}
+
+	f, err := os.Open(loc.Path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening backup archive: %w", err)
+	}
+	defer f.Close()
+
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil, fmt.Errorf("%s not found in %s", loc.Entry, loc.Path)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading backup archive: %w", err)
+		}
+		if hdr.Name == loc.Entry {
+			_, err = io.Copy(w, tr)
+			return hdr.FileInfo(), err
+		}
+	}
+}
+
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
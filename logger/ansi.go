@@ -0,0 +1,11 @@
+package logger
+
+import "regexp"
+
+// ansiPattern matches CSI escape sequences such as colors and cursor moves.
+var ansiPattern = regexp.MustCompile(`\x1b\[[0-9;?]*[ -/]*[@-~]`)
+
+// StripANSI removes terminal escape sequences from s.
+func StripANSI(s string) string {
+	return ansiPattern.ReplaceAllString(s, "")
+}
@@ -1,9 +1,12 @@
 package logger
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"log/slog"
 	"os"
+	"runtime"
 	"strings"
 	"time"
 )
@@ -12,17 +15,45 @@ type Console struct {
 	Logger    *slog.Logger
 	ShowTime  bool
 	Colorized bool
+
+	// Structured is set for JSON output. Widgets that redraw the terminal
+	// then stay silent.
+	Structured bool
+
+	// Interactive is set when the output is a terminal. Otherwise widgets
+	// print occasional plain lines instead of redrawing in place.
+	Interactive bool
+
+	// Quiet is set with -q. The progress bar, dashboard and spinner then
+	// stay silent.
+	Quiet bool
+
+	// Terminal is the single writer for the console's output. Log records,
+	// tables, boxes and live widgets all go through it.
+	Terminal *Terminal
 }
 
 func NewConsole(opts *RichLoggerOptions) *Console {
 	if opts == nil {
 		opts = DefaultOptions()
 	}
+	if opts.Output == nil {
+		opts.Output = os.Stdout
+	}
+
+	term := NewTerminal(opts.Output)
+	termOpts := *opts
+	termOpts.Output = term
 
 	return &Console{
-		Logger:    NewRichLogger(opts),
-		ShowTime:  true,
-		Colorized: opts.EnableColors,
+		Logger:     NewRichLogger(&termOpts),
+		ShowTime:   true,
+		Colorized:  opts.EnableColors && !opts.EnableJSON,
+		Structured: opts.EnableJSON,
+		Quiet:      opts.Quiet,
+
+		Interactive: term.Interactive(),
+		Terminal:    term,
 	}
 }
 
@@ -35,54 +66,87 @@ func (c *Console) StartTimer(name string) *Timer {
 }
 
 func (c *Console) Success(format string, args ...interface{}) {
-	msg := "✓ " + fmt.Sprintf(format, args...)
-	if c.Colorized {
-		msg = Green + Bold + msg + Reset
-	}
-	c.Logger.Info(msg)
+	c.log(slog.LevelInfo, style{"✓ ", Green + Bold}, fmt.Sprintf(format, args...))
 }
 
 func (c *Console) Info(format string, args ...interface{}) {
-	msg := "ℹ " + fmt.Sprintf(format, args...)
-	if c.Colorized {
-		msg = Blue + Bold + msg + Reset
-	}
-	c.Logger.Info(msg)
+	c.log(slog.LevelInfo, style{"ℹ ", Blue + Bold}, fmt.Sprintf(format, args...))
+}
+
+func (c *Console) Debug(format string, args ...interface{}) {
+	c.log(slog.LevelDebug, style{"· ", Cyan}, fmt.Sprintf(format, args...))
+}
+
+// Verbose logs at LevelVerbose, shown with -v.
+func (c *Console) Verbose(format string, args ...interface{}) {
+	c.log(LevelVerbose, style{"✓ ", Green}, fmt.Sprintf(format, args...))
 }
 
 func (c *Console) Log(format string, args ...interface{}) {
-	msg := fmt.Sprintf(format, args...)
-	if c.Colorized {
-		msg = White + msg + Reset
-	}
-	c.Logger.Info(msg)
+	c.log(slog.LevelInfo, style{"", White}, fmt.Sprintf(format, args...))
 }
 
 func (c *Console) Warn(format string, args ...interface{}) {
-	msg := "⚠ " + fmt.Sprintf(format, args...)
-	if c.Colorized {
-		msg = Yellow + Bold + msg + Reset
-	}
-	c.Logger.Warn(msg)
+	c.log(slog.LevelWarn, style{"⚠ ", Yellow + Bold}, fmt.Sprintf(format, args...))
 }
 
 func (c *Console) Error(format string, args ...interface{}) {
-	msg := "✖ " + fmt.Sprintf(format, args...)
-	if c.Colorized {
-		msg = Red + Bold + msg + Reset
-	}
-	c.Logger.Error(msg)
+	c.log(slog.LevelError, style{"✖ ", Red + Bold}, fmt.Sprintf(format, args...))
 }
 
 func (c *Console) Fatal(format string, args ...interface{}) {
-	msg := "💀 " + fmt.Sprintf(format, args...)
-	if c.Colorized {
-		msg = BgRed + White + Bold + msg + Reset
-	}
-	c.Logger.Error(msg)
+	c.log(slog.LevelError, style{"💀 ", BgRed + White + Bold}, fmt.Sprintf(format, args...))
 	os.Exit(1)
 }
 
+// Successw, Verbosew, Infow, Debugw, Warnw and Errorw log a fixed message with slog
+// key-value attributes, so log aggregators can index the values instead of
+// parsing them out of the text.
+func (c *Console) Successw(msg string, args ...any) {
+	c.log(slog.LevelInfo, style{"✓ ", Green + Bold}, msg, args...)
+}
+
+func (c *Console) Verbosew(msg string, args ...any) {
+	c.log(LevelVerbose, style{"✓ ", Green}, msg, args...)
+}
+
+func (c *Console) Infow(msg string, args ...any) {
+	c.log(slog.LevelInfo, style{"ℹ ", Blue + Bold}, msg, args...)
+}
+
+func (c *Console) Debugw(msg string, args ...any) {
+	c.log(slog.LevelDebug, style{"· ", Cyan}, msg, args...)
+}
+
+func (c *Console) Warnw(msg string, args ...any) {
+	c.log(slog.LevelWarn, style{"⚠ ", Yellow + Bold}, msg, args...)
+}
+
+func (c *Console) Errorw(msg string, args ...any) {
+	c.log(slog.LevelError, style{"✖ ", Red + Bold}, msg, args...)
+}
+
+// Enabled reports whether messages at level are logged, to skip building
+// expensive attributes.
+func (c *Console) Enabled(level slog.Level) bool {
+	return c.Logger.Enabled(context.Background(), level)
+}
+
+// log records the caller of the Console method as the source.
+func (c *Console) log(level slog.Level, st style, msg string, args ...any) {
+	ctx := context.Background()
+	if !c.Logger.Enabled(ctx, level) {
+		return
+	}
+
+	var pcs [1]uintptr
+	runtime.Callers(3, pcs[:])
+	r := slog.NewRecord(time.Now(), level, msg, pcs[0])
+	r.AddAttrs(slog.Any(styleKey, st))
+	r.Add(args...)
+	_ = c.Logger.Handler().Handle(ctx, r)
+}
+
 func (c *Console) StartSpinner(message string) *Spinner {
 	s := &Spinner{
 		Message: message,
@@ -96,32 +160,48 @@ func (c *Console) StartSpinner(message string) *Spinner {
 }
 
 func (c *Console) NewProgressBar(total int64, label string) *ProgressBar {
-	return NewProgressBar(total, label, c.Logger)
+	bar := NewProgressBar(total, label, c.Logger)
+	bar.hidden = c.Structured || c.Quiet
+	bar.interactive = c.Interactive
+	bar.term = c.Terminal
+	return bar
 }
 
 func (c *Console) NewTable(headers []string) *Table {
-	return NewTable(headers, c.Logger)
+	t := NewTable(headers, c.Logger)
+	t.structured = c.Structured
+	t.out = c.Terminal
+	t.maxWidth = c.Terminal.Width()
+	return t
 }
 
 func (c *Console) Box(title string, content string) {
 	lines := splitLines(content)
-	maxWidth := len(title)
+
+	if c.Structured {
+		c.Logger.Info(title, "content", lines)
+		return
+	}
+
+	maxWidth := StringWidth(title)
 
 	for _, line := range lines {
-		if len(line) > maxWidth {
-			maxWidth = len(line)
+		if w := StringWidth(line); w > maxWidth {
+			maxWidth = w
 		}
 	}
 
 	maxWidth += 4
 
-	fmt.Println("┌" + "─" + title + "─" + strings.Repeat("─", maxWidth-len(title)-2) + "┐")
+	var sb strings.Builder
+	sb.WriteString("┌" + "─" + title + "─" + strings.Repeat("─", maxWidth-StringWidth(title)) + "┐\n")
 
 	for _, line := range lines {
-		fmt.Println("│ " + line + strings.Repeat(" ", maxWidth-len(line)) + " │")
+		sb.WriteString("│ " + PadRight(line, maxWidth) + " │\n")
 	}
 
-	fmt.Println("└" + strings.Repeat("─", maxWidth+2) + "┘")
+	sb.WriteString("└" + strings.Repeat("─", maxWidth+2) + "┘\n")
+	io.WriteString(c.Terminal, sb.String())
 }
 
 func splitLines(text string) []string {
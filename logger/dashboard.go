@@ -0,0 +1,25 @@
+package logger
+
+// Dashboard is a multi-line live region drawn through the console's
+// Terminal. It only draws when the output is a terminal.
+type Dashboard struct {
+	term *Terminal
+}
+
+func (c *Console) NewDashboard() *Dashboard {
+	return &Dashboard{term: c.Terminal}
+}
+
+// Interactive reports whether the dashboard can redraw in place.
+func (d *Dashboard) Interactive() bool {
+	return d.term.Interactive()
+}
+
+// Draw replaces the previously drawn lines with lines.
+func (d *Dashboard) Draw(lines []string) {
+	d.term.SetLive(lines)
+}
+
+func (d *Dashboard) Clear() {
+	d.term.ClearLive()
+}
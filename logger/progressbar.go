@@ -18,8 +18,25 @@ type ProgressBar struct {
 	current   int64
 	width     int
 	complete  bool
+
+	discovering bool
+	detached    bool
+	hidden      bool
+
+	// Without a terminal the bar prints a plain line every
+	// progressLineStep percent or progressLineInterval instead.
+	interactive bool
+	lastStep    int64
+	lastLine    time.Time
+
+	term *Terminal
 }
 
+const (
+	progressLineStep     = 10
+	progressLineInterval = 30 * time.Second
+)
+
 func NewProgressBar(total int64, label string, logger *slog.Logger) *ProgressBar {
 	return &ProgressBar{
 		total:     total,
@@ -27,6 +44,10 @@ func NewProgressBar(total int64, label string, logger *slog.Logger) *ProgressBar
 		label:     label,
 		startTime: time.Now(),
 		logger:    logger,
+
+		interactive: true,
+		lastLine:    time.Now(),
+		term:        NewTerminal(os.Stdout),
 	}
 }
 
@@ -54,6 +75,42 @@ func (p *ProgressBar) Set(value int64) {
 	p.render()
 }
 
+// AddTotal grows the total while the work is still being discovered.
+func (p *ProgressBar) AddTotal(amount int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.total += amount
+	p.render()
+}
+
+// SetDiscovering marks the total as incomplete. The ETA is replaced with
+// "discovering…" until it is cleared.
+func (p *ProgressBar) SetDiscovering(discovering bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.discovering = discovering
+	p.render()
+}
+
+// SetDetached stops the bar from drawing itself, for when another widget
+// draws Line instead.
+func (p *ProgressBar) SetDetached(detached bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.detached = detached
+}
+
+// Line returns the current bar without drawing it.
+func (p *ProgressBar) Line() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.line()
+}
+
 func (p *ProgressBar) Complete() {
 	p.mu.Lock()
 	defer p.mu.Unlock()
@@ -63,36 +120,93 @@ func (p *ProgressBar) Complete() {
 	}
 
 	p.current = p.total
+	if !p.interactive {
+		if !p.hidden && p.lastStep < 100 && p.total > 0 {
+			p.printLine()
+		}
+		p.complete = true
+		return
+	}
+
 	p.render()
 	p.complete = true
-	fmt.Fprintln(os.Stdout)
+	if !p.hidden {
+		p.term.Commit()
+	}
 }
 
 func (p *ProgressBar) render() {
-	if p.complete {
+	if p.complete || p.detached || p.hidden {
+		return
+	}
+
+	if !p.interactive {
+		p.maybePrintLine()
 		return
 	}
 
-	percent := float64(p.current) / float64(p.total) * 100
-	filled := int(float64(p.width) * float64(p.current) / float64(p.total))
+	p.term.SetLive([]string{p.line()})
+}
 
+// maybePrintLine prints a plain progress line when another step is reached
+// or the last line is older than progressLineInterval.
+func (p *ProgressBar) maybePrintLine() {
+	if p.total == 0 {
+		return
+	}
+
+	step := p.current * 100 / p.total / progressLineStep * progressLineStep
+	if step <= p.lastStep && time.Since(p.lastLine) < progressLineInterval {
+		return
+	}
+	p.printLine()
+}
+
+func (p *ProgressBar) printLine() {
+	var percent int64
+	if p.total > 0 {
+		percent = p.current * 100 / p.total
+	}
+	p.lastStep = percent / progressLineStep * progressLineStep
+	p.lastLine = time.Now()
+
+	status := "ETA " + formatDuration(p.eta())
+	if p.discovering {
+		status = "discovering"
+	}
+	fmt.Fprintf(p.term, "%s: %d%% (%d/%d), %s\n", p.label, percent, p.current, p.total, status)
+}
+
+func (p *ProgressBar) eta() time.Duration {
+	if p.current == 0 {
+		return 0
+	}
 	elapsed := time.Since(p.startTime)
-	var eta time.Duration
-	if p.current > 0 {
-		eta = time.Duration(float64(elapsed) * float64(p.total-p.current) / float64(p.current))
+	return time.Duration(float64(elapsed) * float64(p.total-p.current) / float64(p.current))
+}
+
+func (p *ProgressBar) line() string {
+	var percent float64
+	filled := 0
+	if p.total > 0 {
+		percent = float64(p.current) / float64(p.total) * 100
+		filled = int(float64(p.width) * float64(p.current) / float64(p.total))
 	}
 
-	bar := fmt.Sprintf("\r%s [%s%s] %3.0f%% %d/%d ETA: %s ",
+	status := "ETA: " + formatDuration(p.eta())
+	if p.discovering {
+		status = "discovering…"
+	}
+
+	return fmt.Sprintf("%s [%s%s] %3.0f%% %d/%d %s ",
 		p.label,
 		strings.Repeat("█", filled),
 		strings.Repeat("░", p.width-filled),
 		percent,
 		p.current,
 		p.total,
-		formatDuration(eta),
+		status,
 	)
-
-	fmt.Fprint(os.Stdout, bar)
 }
 
 func formatDuration(d time.Duration) string {
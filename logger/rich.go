@@ -8,6 +8,7 @@ import (
 	"log/slog"
 	"os"
 	"runtime"
+	"strconv"
 	"strings"
 	"sync"
 )
@@ -41,13 +42,32 @@ type RichLoggerOptions struct {
 	TimestampInJSON  bool
 	CompactJSON      bool
 	EnableSeparators bool
+
+	// Quiet hides the progress bar, dashboard, spinner and summary, as
+	// with -q. Level only filters log records.
+	Quiet bool
+
+	// Outputs are written in addition to Output, each with its own level
+	// and format.
+	Outputs []Output
+}
+
+// Output is one destination of a RichHandler.
+type Output struct {
+	Writer     io.Writer
+	Level      slog.Level
+	JSON       bool
+	Compact    bool
+	Colors     bool
+	Separators bool
+	TimeFormat string
 }
 
 func DefaultOptions() *RichLoggerOptions {
 	return &RichLoggerOptions{
 		Level:            slog.LevelInfo,
 		AddSource:        false,
-		EnableColors:     true,
+		EnableColors:     ColorEnabled(ColorAuto, os.Stdout),
 		TimeFormat:       "2006-01-02 15:04:05.000",
 		Output:           os.Stdout,
 		ShowLoggerName:   true,
@@ -57,9 +77,54 @@ func DefaultOptions() *RichLoggerOptions {
 	}
 }
 
+// LevelVerbose sits between debug and info. It carries per-file results,
+// which are too many for the default output but cheap to produce.
+const LevelVerbose = slog.Level(-2)
+
+// ParseLevel parses debug, verbose, info, warn or error.
+func ParseLevel(s string) (slog.Level, error) {
+	if strings.EqualFold(s, "verbose") {
+		return LevelVerbose, nil
+	}
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(s)); err != nil {
+		return 0, err
+	}
+	return level, nil
+}
+
+func levelName(level slog.Level) string {
+	if level == LevelVerbose {
+		return "VERBOSE"
+	}
+	return level.String()
+}
+
+// styleKey is the attribute under which Console passes a message's icon and
+// color. Text outputs render it; it is never written as an attribute.
+const styleKey = "logger.style"
+
+type style struct {
+	Icon  string
+	Color string
+}
+
+func recordStyle(record slog.Record) style {
+	var st style
+	record.Attrs(func(a slog.Attr) bool {
+		if a.Key == styleKey {
+			st, _ = a.Value.Any().(style)
+			return false
+		}
+		return true
+	})
+	return st
+}
+
 type RichHandler struct {
 	opts    *RichLoggerOptions
-	mu      sync.Mutex
+	outputs []Output
+	mu      *sync.Mutex
 	attrs   []slog.Attr
 	groups  []string
 	loggers map[string]bool
@@ -73,14 +138,37 @@ func NewRichHandler(opts *RichLoggerOptions) *RichHandler {
 		opts.Output = os.Stdout
 	}
 
+	outputs := []Output{{
+		Writer:     opts.Output,
+		Level:      opts.Level,
+		JSON:       opts.EnableJSON,
+		Compact:    opts.CompactJSON,
+		Colors:     opts.EnableColors,
+		Separators: opts.EnableSeparators,
+		TimeFormat: opts.TimeFormat,
+	}}
+	for _, out := range opts.Outputs {
+		if out.TimeFormat == "" {
+			out.TimeFormat = opts.TimeFormat
+		}
+		outputs = append(outputs, out)
+	}
+
 	return &RichHandler{
 		opts:    opts,
+		outputs: outputs,
+		mu:      &sync.Mutex{},
 		loggers: make(map[string]bool),
 	}
 }
 
 func (h *RichHandler) Enabled(_ context.Context, level slog.Level) bool {
-	return level >= h.opts.Level
+	for _, out := range h.outputs {
+		if level >= out.Level {
+			return true
+		}
+	}
+	return false
 }
 
 func (h *RichHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
@@ -98,6 +186,8 @@ func (h *RichHandler) WithGroup(name string) slog.Handler {
 func (h *RichHandler) clone() *RichHandler {
 	h2 := &RichHandler{
 		opts:    h.opts,
+		outputs: h.outputs,
+		mu:      h.mu,
 		attrs:   make([]slog.Attr, len(h.attrs)),
 		groups:  make([]string, len(h.groups)),
 		loggers: make(map[string]bool),
@@ -110,27 +200,40 @@ func (h *RichHandler) clone() *RichHandler {
 	return h2
 }
 
-func (h *RichHandler) Handle(ctx context.Context, record slog.Record) error {
+func (h *RichHandler) Handle(_ context.Context, record slog.Record) error {
 	h.mu.Lock()
 	defer h.mu.Unlock()
 
-	if h.opts.EnableJSON {
-		return h.handleJSON(ctx, record)
+	var firstErr error
+	for _, out := range h.outputs {
+		if record.Level < out.Level {
+			continue
+		}
+
+		var err error
+		if out.JSON {
+			err = h.handleJSON(out, record)
+		} else {
+			err = h.handleText(out, record)
+		}
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
 	}
 
-	return h.handleText(ctx, record)
+	return firstErr
 }
 
-func (h *RichHandler) handleJSON(ctx context.Context, record slog.Record) error {
+func (h *RichHandler) handleJSON(out Output, record slog.Record) error {
 	jsonMap := make(map[string]interface{})
 
 	// Add timestamp
 	if h.opts.TimestampInJSON {
-		jsonMap["time"] = record.Time.Format(h.opts.TimeFormat)
+		jsonMap["time"] = record.Time.Format(out.TimeFormat)
 	}
 
 	// Add level
-	jsonMap["level"] = record.Level.String()
+	jsonMap["level"] = levelName(record.Level)
 
 	// Add source if enabled
 	if h.opts.AddSource && record.PC != 0 {
@@ -140,26 +243,28 @@ func (h *RichHandler) handleJSON(ctx context.Context, record slog.Record) error
 	}
 
 	// Add message
-	jsonMap["msg"] = record.Message
+	jsonMap["msg"] = StripANSI(record.Message)
 
 	// Add attributes
-	addAttrs := func(a slog.Attr) bool {
-		jsonMap[a.Key] = a.Value.Any()
-		return true
-	}
-
 	for _, a := range h.attrs {
-		addAttrs(a)
+		addJSONAttr(jsonMap, a)
 	}
 
+	// Record attributes belong to the groups opened with WithGroup
+	target := jsonMap
+	for _, g := range h.groups {
+		m := make(map[string]interface{})
+		target[g] = m
+		target = m
+	}
 	record.Attrs(func(a slog.Attr) bool {
-		addAttrs(a)
+		addJSONAttr(target, a)
 		return true
 	})
 
 	var jsonData []byte
 	var err error
-	if h.opts.CompactJSON {
+	if out.Compact {
 		jsonData, err = json.Marshal(jsonMap)
 	} else {
 		jsonData, err = json.MarshalIndent(jsonMap, "", "  ")
@@ -168,42 +273,43 @@ func (h *RichHandler) handleJSON(ctx context.Context, record slog.Record) error
 		return err
 	}
 
-	_, err = fmt.Fprintln(h.opts.Output, string(jsonData))
+	_, err = fmt.Fprintln(out.Writer, string(jsonData))
 	return err
 }
 
-func (h *RichHandler) handleText(ctx context.Context, record slog.Record) error {
+func (h *RichHandler) handleText(out Output, record slog.Record) error {
 	var builder strings.Builder
 
 	levelColors := map[slog.Level]string{
 		slog.LevelDebug: Cyan,
+		LevelVerbose:    Blue,
 		slog.LevelInfo:  Green,
 		slog.LevelWarn:  Yellow,
 		slog.LevelError: Red,
 	}
 
 	levelColor := levelColors[record.Level]
-	if !h.opts.EnableColors {
+	if !out.Colors {
 		levelColor = ""
 	}
 
-	timeStr := record.Time.Format(h.opts.TimeFormat)
-	if h.opts.EnableColors {
+	timeStr := record.Time.Format(out.TimeFormat)
+	if out.Colors {
 		builder.WriteString(Blue)
 	}
 	builder.WriteString(timeStr)
 	builder.WriteString(" ")
-	if h.opts.EnableColors {
+	if out.Colors {
 		builder.WriteString(Reset)
 	}
 
-	levelStr := fmt.Sprintf("%-5s", strings.ToUpper(record.Level.String()))
-	if h.opts.EnableColors {
+	levelStr := fmt.Sprintf("%-7s", strings.ToUpper(levelName(record.Level)))
+	if out.Colors {
 		builder.WriteString(levelColor)
 		builder.WriteString(Bold)
 	}
 	builder.WriteString(levelStr)
-	if h.opts.EnableColors {
+	if out.Colors {
 		builder.WriteString(Reset)
 	}
 	builder.WriteString(" ")
@@ -215,40 +321,125 @@ func (h *RichHandler) handleText(ctx context.Context, record slog.Record) error
 		if lastSlash := strings.LastIndex(sourceFile, "/"); lastSlash >= 0 {
 			sourceFile = sourceFile[lastSlash+1:]
 		}
-		if h.opts.EnableColors {
+		if out.Colors {
 			builder.WriteString(Magenta)
 		}
 		builder.WriteString(fmt.Sprintf("%s:%d", sourceFile, f.Line))
-		if h.opts.EnableColors {
+		if out.Colors {
 			builder.WriteString(Reset)
 		}
 		builder.WriteString(" ")
 	}
 
-	if h.opts.EnableColors {
-		builder.WriteString(White)
-		builder.WriteString(Bold)
+	st := recordStyle(record)
+	if out.Colors {
+		if st.Color != "" {
+			builder.WriteString(st.Color)
+		} else {
+			builder.WriteString(White)
+			builder.WriteString(Bold)
+		}
 	}
-	builder.WriteString(record.Message)
-	if h.opts.EnableColors {
+	msg := st.Icon + record.Message
+	if !out.Colors {
+		msg = StripANSI(msg)
+	}
+	builder.WriteString(msg)
+	if out.Colors {
 		builder.WriteString(Reset)
 	}
 
-	if h.opts.EnableSeparators {
+	prefix := ""
+	for _, g := range h.groups {
+		prefix += g + "."
+	}
+	for _, a := range h.attrs {
+		h.appendTextAttr(&builder, out, "", a)
+	}
+	record.Attrs(func(a slog.Attr) bool {
+		h.appendTextAttr(&builder, out, prefix, a)
+		return true
+	})
+
+	if out.Separators {
 		builder.WriteString("\n")
-		if h.opts.EnableColors {
+		if out.Colors {
 			builder.WriteString(Blue)
 		}
 		builder.WriteString(strings.Repeat("─", 80))
-		if h.opts.EnableColors {
+		if out.Colors {
 			builder.WriteString(Reset)
 		}
 	}
 
-	_, err := fmt.Fprintln(h.opts.Output, builder.String())
+	_, err := fmt.Fprintln(out.Writer, builder.String())
 	return err
 }
 
+// appendTextAttr writes a as " key=value", quoting values with spaces and
+// flattening groups into dotted keys.
+func (h *RichHandler) appendTextAttr(b *strings.Builder, out Output, prefix string, a slog.Attr) {
+	v := a.Value.Resolve()
+	if a.Equal(slog.Attr{}) || a.Key == styleKey {
+		return
+	}
+
+	if v.Kind() == slog.KindGroup {
+		if a.Key != "" {
+			prefix += a.Key + "."
+		}
+		for _, ga := range v.Group() {
+			h.appendTextAttr(b, out, prefix, ga)
+		}
+		return
+	}
+
+	b.WriteString(" ")
+	if out.Colors {
+		b.WriteString(Cyan)
+	}
+	b.WriteString(prefix + a.Key + "=")
+	if out.Colors {
+		b.WriteString(Reset)
+	}
+
+	str := v.String()
+	if v.Kind() == slog.KindTime {
+		str = v.Time().Format(out.TimeFormat)
+	}
+	if str == "" || strings.ContainsAny(str, " \t\n\"=") {
+		str = strconv.Quote(str)
+	}
+	b.WriteString(str)
+}
+
+// addJSONAttr stores a in m, nesting groups and turning errors into their
+// messages so they do not marshal as empty objects.
+func addJSONAttr(m map[string]interface{}, a slog.Attr) {
+	v := a.Value.Resolve()
+	if a.Equal(slog.Attr{}) || a.Key == styleKey {
+		return
+	}
+
+	if v.Kind() == slog.KindGroup {
+		target := m
+		if a.Key != "" {
+			target = make(map[string]interface{})
+			m[a.Key] = target
+		}
+		for _, ga := range v.Group() {
+			addJSONAttr(target, ga)
+		}
+		return
+	}
+
+	if err, ok := v.Any().(error); ok {
+		m[a.Key] = err.Error()
+		return
+	}
+	m[a.Key] = v.Any()
+}
+
 func NewRichLogger(opts *RichLoggerOptions) *slog.Logger {
 	if opts == nil {
 		opts = DefaultOptions()
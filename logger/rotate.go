@@ -0,0 +1,124 @@
+package logger
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// RotatingFile is a log file that keeps up to Keep older copies as
+// path.1 (newest) … path.N. It rotates when a write would grow it beyond
+// MaxSize, and on open when PerRun is set.
+type RotatingFile struct {
+	Path    string
+	MaxSize int64
+	Keep    int
+	PerRun  bool
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+func OpenRotatingFile(path string, maxSize int64, keep int, perRun bool) (*RotatingFile, error) {
+	r := &RotatingFile{Path: path, MaxSize: maxSize, Keep: keep, PerRun: perRun}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("error creating log directory: %w", err)
+	}
+
+	if perRun {
+		if _, err := os.Stat(path); err == nil {
+			if err := r.rotate(); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *RotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.file == nil {
+		return 0, os.ErrClosed
+	}
+
+	if r.MaxSize > 0 && r.size > 0 && r.size+int64(len(p)) > r.MaxSize {
+		if err := r.file.Close(); err != nil {
+			return 0, err
+		}
+		r.file = nil
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+		if err := r.open(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+func (r *RotatingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.file == nil {
+		return nil
+	}
+	err := r.file.Close()
+	r.file = nil
+	return err
+}
+
+func (r *RotatingFile) open() error {
+	f, err := os.OpenFile(r.Path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("error opening log file: %w", err)
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("error opening log file: %w", err)
+	}
+
+	r.file = f
+	r.size = info.Size()
+	return nil
+}
+
+// rotate shifts path.N-1 to path.N and so on, then moves the current file to
+// path.1. Copies beyond Keep are removed; with Keep 0 the file is discarded.
+func (r *RotatingFile) rotate() error {
+	if r.Keep <= 0 {
+		if err := os.Remove(r.Path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("error rotating log file: %w", err)
+		}
+		return nil
+	}
+
+	os.Remove(r.backup(r.Keep))
+	for i := r.Keep - 1; i >= 1; i-- {
+		if err := os.Rename(r.backup(i), r.backup(i+1)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("error rotating log file: %w", err)
+		}
+	}
+	if err := os.Rename(r.Path, r.backup(1)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("error rotating log file: %w", err)
+	}
+	return nil
+}
+
+func (r *RotatingFile) backup(n int) string {
+	return fmt.Sprintf("%s.%d", r.Path, n)
+}
@@ -13,16 +13,26 @@ type Spinner struct {
 }
 
 func (s *Spinner) Start() {
+	term := s.Console.Terminal
+
+	if s.Console.Structured || s.Console.Quiet || !s.Console.Interactive {
+		if !s.Console.Structured && !s.Console.Quiet {
+			fmt.Fprintf(term, "%s…\n", s.Message)
+		}
+		go func() { <-s.Done }()
+		return
+	}
+
 	go func() {
 		i := 0
 		for {
 			select {
 			case <-s.Done:
-				fmt.Print("\r")
+				term.ClearLive()
 				return
 			default:
 				frame := s.Frames[i%len(s.Frames)]
-				fmt.Printf("\r%s %s ", frame, s.Message)
+				term.SetLive([]string{frame + " " + s.Message})
 				i++
 				time.Sleep(100 * time.Millisecond)
 			}
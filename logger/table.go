@@ -2,27 +2,44 @@ package logger
 
 import (
 	"fmt"
+	"io"
 	"log/slog"
+	"os"
+	"regexp"
 	"strings"
 )
 
+// minColumnWidth is the narrowest a column is cut to when the table does
+// not fit the terminal.
+const minColumnWidth = 4
+
+// numberPattern matches cells that start with a number, such as 12, 3/4 or
+// 1.5 MB. Columns holding only such cells are right-aligned.
+var numberPattern = regexp.MustCompile(`^[-+]?[0-9]`)
+
 type Table struct {
 	headers     []string
 	rows        [][]string
 	columnWidth []int
 	logger      *slog.Logger
+	structured  bool
+	out         io.Writer
+
+	// maxWidth is the terminal width the table is cut to, 0 if unknown.
+	maxWidth int
 }
 
 func NewTable(headers []string, logger *slog.Logger) *Table {
 	widths := make([]int, len(headers))
 	for i, h := range headers {
-		widths[i] = len(h)
+		widths[i] = StringWidth(h)
 	}
 
 	return &Table{
 		headers:     headers,
 		columnWidth: widths,
 		logger:      logger,
+		out:         os.Stdout,
 	}
 }
 
@@ -36,8 +53,8 @@ func (t *Table) AddRow(cells ...string) {
 	}
 
 	for i, cell := range cells {
-		if len(cell) > t.columnWidth[i] {
-			t.columnWidth[i] = len(cell)
+		if w := StringWidth(cell); w > t.columnWidth[i] {
+			t.columnWidth[i] = w
 		}
 	}
 
@@ -45,48 +62,101 @@ func (t *Table) AddRow(cells ...string) {
 }
 
 func (t *Table) Print() {
-	var sb strings.Builder
+	if t.structured {
+		t.log()
+		return
+	}
 
-	format := "│"
-	separator := "├"
-	footer := "└"
+	widths := t.fitWidths()
+	numeric := t.numericColumns()
 
-	for _, width := range t.columnWidth {
-		format += " %-" + fmt.Sprintf("%d", width) + "s │"
-		separator += strings.Repeat("─", width+2) + "┼"
-		footer += strings.Repeat("─", width+2) + "┴"
+	border := func(left, middle, right string) string {
+		parts := make([]string, len(widths))
+		for i, width := range widths {
+			parts[i] = strings.Repeat("─", width+2)
+		}
+		return left + strings.Join(parts, middle) + right
 	}
 
-	separator = separator[:len(separator)-1] + "┤"
-	footer = footer[:len(footer)-1] + "┘"
-
-	sb.WriteString("┌")
-	for i, width := range t.columnWidth {
-		sb.WriteString(strings.Repeat("─", width+2))
-		if i < len(t.columnWidth)-1 {
-			sb.WriteString("┬")
+	line := func(cells []string) string {
+		parts := make([]string, len(cells))
+		for i, cell := range cells {
+			cell = Truncate(cell, widths[i])
+			if numeric[i] {
+				cell = PadLeft(cell, widths[i])
+			} else {
+				cell = PadRight(cell, widths[i])
+			}
+			parts[i] = " " + cell + " "
 		}
+		return "│" + strings.Join(parts, "│") + "│"
+	}
+
+	var sb strings.Builder
+	sb.WriteString(border("┌", "┬", "┐") + "\n")
+	sb.WriteString(line(t.headers) + "\n")
+	sb.WriteString(border("├", "┼", "┤") + "\n")
+	for _, row := range t.rows {
+		sb.WriteString(line(row) + "\n")
+	}
+	sb.WriteString(border("└", "┴", "┘"))
+	fmt.Fprintln(t.out, sb.String())
+}
+
+// fitWidths narrows the widest columns until the table fits maxWidth, or
+// every column is down to minColumnWidth.
+func (t *Table) fitWidths() []int {
+	widths := append([]int(nil), t.columnWidth...)
+	if t.maxWidth <= 0 {
+		return widths
 	}
-	sb.WriteString("┐\n")
 
-	headerArgs := make([]interface{}, len(t.headers))
-	for i, h := range t.headers {
-		headerArgs[i] = h
+	total := 1
+	for _, width := range widths {
+		total += width + 3
 	}
-	sb.WriteString(fmt.Sprintf(format, headerArgs...))
-	sb.WriteString("\n")
 
-	sb.WriteString(separator)
-	sb.WriteString("\n")
+	for total > t.maxWidth {
+		widest := 0
+		for i, width := range widths {
+			if width > widths[widest] {
+				widest = i
+			}
+		}
+		if widths[widest] <= minColumnWidth {
+			break
+		}
+		widths[widest]--
+		total--
+	}
+	return widths
+}
+
+// numericColumns reports the columns whose non-empty cells all start with a
+// number.
+func (t *Table) numericColumns() []bool {
+	numeric := make([]bool, len(t.headers))
+	for i := range t.headers {
+		for _, row := range t.rows {
+			if row[i] == "" {
+				continue
+			}
+			numeric[i] = numberPattern.MatchString(row[i])
+			if !numeric[i] {
+				break
+			}
+		}
+	}
+	return numeric
+}
 
+// log emits one record per row, keyed by the column headers.
+func (t *Table) log() {
 	for _, row := range t.rows {
-		args := make([]interface{}, len(row))
+		args := make([]any, 0, 2*len(row))
 		for i, cell := range row {
-			args[i] = cell
+			args = append(args, t.headers[i], cell)
 		}
-		sb.WriteString(fmt.Sprintf(format, args...))
-		sb.WriteString("\n")
+		t.logger.Info("table row", args...)
 	}
-	sb.WriteString(footer)
-	fmt.Println(sb.String())
 }
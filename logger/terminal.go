@@ -0,0 +1,194 @@
+package logger
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type ColorMode string
+
+const (
+	ColorAuto   ColorMode = "auto"
+	ColorAlways ColorMode = "always"
+	ColorNever  ColorMode = "never"
+)
+
+func ParseColorMode(s string) (ColorMode, error) {
+	switch m := ColorMode(strings.ToLower(s)); m {
+	case ColorAuto, ColorAlways, ColorNever:
+		return m, nil
+	case "":
+		return ColorAuto, nil
+	}
+	return "", fmt.Errorf("error: unknown color mode %q (auto, always, never)", s)
+}
+
+// ColorEnabled decides whether to write color codes to w. In auto mode
+// NO_COLOR disables colors and CLICOLOR_FORCE enables them
+// (https://no-color.org, https://bixense.com/clicolors); otherwise colors are
+// used only when w is an interactive terminal.
+func ColorEnabled(mode ColorMode, w io.Writer) bool {
+	switch mode {
+	case ColorAlways:
+		return true
+	case ColorNever:
+		return false
+	}
+
+	if os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+	if force := os.Getenv("CLICOLOR_FORCE"); force != "" && force != "0" {
+		return true
+	}
+	return Interactive(w)
+}
+
+// Interactive reports whether w is a terminal that understands cursor
+// movement, so output can be redrawn in place.
+func Interactive(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok || !IsTerminal(f) {
+		return false
+	}
+	return os.Getenv("TERM") != "dumb"
+}
+
+// IsTerminal reports whether f is a character device such as a terminal.
+func IsTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// Terminal owns an output stream. Log records, tables and boxes are written
+// through it as static output, which is printed above the live region (the
+// progress bar, dashboard or spinner). The live region is erased before and
+// redrawn after every write, so the two never end up on the same line.
+//
+// When the stream is not interactive the live region is never drawn and
+// writes pass straight through.
+type Terminal struct {
+	mu          sync.Mutex
+	out         io.Writer
+	interactive bool
+	live        []string
+	drawn       int
+}
+
+func NewTerminal(out io.Writer) *Terminal {
+	return &Terminal{out: out, interactive: Interactive(out)}
+}
+
+// Interactive reports whether the live region is drawn.
+func (t *Terminal) Interactive() bool {
+	return t.interactive
+}
+
+// Width returns the number of columns of the terminal, falling back to
+// $COLUMNS, or 0 when it is unknown.
+func (t *Terminal) Width() int {
+	if f, ok := t.out.(*os.File); ok && IsTerminal(f) {
+		if w := terminalWidth(f); w > 0 {
+			return w
+		}
+	}
+	if cols, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && cols > 0 {
+		return cols
+	}
+	return 0
+}
+
+// Write prints p as static output above the live region.
+func (t *Terminal) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if !t.interactive || t.drawn == 0 {
+		return t.out.Write(p)
+	}
+
+	var sb strings.Builder
+	t.erase(&sb)
+	sb.Write(p)
+	if len(p) > 0 && p[len(p)-1] != '\n' {
+		sb.WriteByte('\n')
+	}
+	t.draw(&sb)
+
+	if _, err := io.WriteString(t.out, sb.String()); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// SetLive replaces the live region with lines and redraws it.
+func (t *Terminal) SetLive(lines []string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if !t.interactive {
+		return
+	}
+
+	var sb strings.Builder
+	t.erase(&sb)
+	t.live = append(t.live[:0], lines...)
+	t.draw(&sb)
+	io.WriteString(t.out, sb.String())
+}
+
+// ClearLive erases the live region.
+func (t *Terminal) ClearLive() {
+	t.SetLive(nil)
+}
+
+// Commit leaves the live region on screen as static output, for a final
+// progress bar that should stay visible.
+func (t *Terminal) Commit() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if !t.interactive || t.drawn == 0 {
+		return
+	}
+
+	io.WriteString(t.out, "\n")
+	t.live = t.live[:0]
+	t.drawn = 0
+}
+
+func (t *Terminal) erase(sb *strings.Builder) {
+	if t.drawn == 0 {
+		return
+	}
+
+	sb.WriteString("\r\033[2K")
+	for i := 1; i < t.drawn; i++ {
+		sb.WriteString("\033[1A\033[2K")
+	}
+	t.drawn = 0
+}
+
+// draw writes the live region, leaving the cursor at the end of its last
+// line so the next erase knows where it starts. Lines are cut to the
+// terminal width, since a wrapped line would not be erased completely.
+func (t *Terminal) draw(sb *strings.Builder) {
+	width := t.Width()
+	for i, line := range t.live {
+		if width > 0 {
+			line = Truncate(line, width)
+		}
+		sb.WriteString(line)
+		if i < len(t.live)-1 {
+			sb.WriteString("\n")
+		}
+	}
+	t.drawn = len(t.live)
+}
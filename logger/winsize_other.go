@@ -0,0 +1,9 @@
+//go:build !linux && !darwin
+
+package logger
+
+import "os"
+
+func terminalWidth(f *os.File) int {
+	return 0
+}
@@ -6,19 +6,52 @@ import (
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == encodeWorkerCommand {
+		if err := RunEncodeWorker(os.Args[2:]); err != nil {
+			os.Stderr.WriteString(err.Error() + "\n")
+			os.Exit(1)
+		}
+		return
+	}
+
 	console := logger.NewConsole(logger.DefaultOptions())
 
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "prune-backups":
+			if err := RunPruneBackups(console, os.Args[2:]); err != nil {
+				console.Error("Prune error: %v", err)
+				os.Exit(ExitTotalFailure)
+			}
+			return
+		case "undo":
+			if err := RunUndo(console, os.Args[2:]); err != nil {
+				console.Error("Undo error: %v", err)
+				os.Exit(ExitTotalFailure)
+			}
+			return
+		}
+	}
+
 	cfg, err := ParseConfig(console)
 	if err != nil {
 		os.Stderr.WriteString("Configuration error: " + err.Error() + "\n")
-		os.Exit(1)
+		os.Exit(ExitConfigError)
+	}
+
+	opts, err := cfg.LoggerOptions()
+	if err != nil {
+		os.Stderr.WriteString("Configuration error: " + err.Error() + "\n")
+		os.Exit(ExitConfigError)
 	}
 
+	console = logger.NewConsole(opts)
 	processor := NewProcessor(cfg, console)
 
 	if err := processor.ProcessPath(cfg.InputPath); err != nil {
-		console.Error("Processing error: %v", err)
-		os.Exit(1)
+		code := exitCode(err)
+		console.Errorw("Processing error", "error", err, "exit_code", code)
+		os.Exit(code)
 	}
 
 	console.Success("All processing completed successfully")
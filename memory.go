@@ -0,0 +1,111 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"image"
+	"os"
+	"sync"
+)
+
+// bytesPerPixel is a rough peak cost of one pixel while a file is converted:
+// the decoded source, the RGBA copy handed to the encoder, encoder buffers and
+// the decoded output used for verification.
+const bytesPerPixel = 16
+
+// memoryBudget is a FIFO weighted semaphore over estimated bytes. Requests
+// larger than the whole budget are clamped to it, so they run alone.
+type memoryBudget struct {
+	mu      sync.Mutex
+	size    int64
+	used    int64
+	waiters list.List
+}
+
+type budgetWaiter struct {
+	n     int64
+	ready chan struct{}
+}
+
+func newMemoryBudget(size int64) *memoryBudget {
+	return &memoryBudget{size: size}
+}
+
+// Acquire blocks until n bytes are available and returns the amount actually
+// reserved, which must be passed to Release.
+func (b *memoryBudget) Acquire(ctx context.Context, n int64) (int64, error) {
+	if n > b.size {
+		n = b.size
+	}
+
+	b.mu.Lock()
+	if b.size-b.used >= n && b.waiters.Len() == 0 {
+		b.used += n
+		b.mu.Unlock()
+		return n, nil
+	}
+
+	w := &budgetWaiter{n: n, ready: make(chan struct{})}
+	elem := b.waiters.PushBack(w)
+	b.mu.Unlock()
+
+	select {
+	case <-w.ready:
+		return n, nil
+	case <-ctx.Done():
+		b.mu.Lock()
+		select {
+		case <-w.ready:
+			// Granted while cancelling; hand it back.
+			b.used -= n
+			b.notify()
+		default:
+			b.waiters.Remove(elem)
+			b.notify()
+		}
+		b.mu.Unlock()
+		return 0, ctx.Err()
+	}
+}
+
+func (b *memoryBudget) Release(n int64) {
+	b.mu.Lock()
+	b.used -= n
+	b.notify()
+	b.mu.Unlock()
+}
+
+// notify admits waiters in order while they fit. Callers hold b.mu.
+func (b *memoryBudget) notify() {
+	for {
+		front := b.waiters.Front()
+		if front == nil {
+			return
+		}
+		w := front.Value.(*budgetWaiter)
+		if b.size-b.used < w.n {
+			return
+		}
+		b.used += w.n
+		b.waiters.Remove(front)
+		close(w.ready)
+	}
+}
+
+// estimateMemory reads the image header and returns the estimated peak bytes
+// needed to convert the file.
+func estimateMemory(filePath string) (int64, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return 0, fmt.Errorf("error opening file: %w", err)
+	}
+	defer f.Close()
+
+	cfg, _, err := image.DecodeConfig(f)
+	if err != nil {
+		return 0, decodeFailure(fmt.Errorf("error reading image header: %w", err))
+	}
+
+	return int64(cfg.Width) * int64(cfg.Height) * bytesPerPixel, nil
+}
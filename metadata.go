@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// applyMetadata copies the selected attributes of the source onto the
+// converted file. Ownership is only changed when running as root.
+func (p *Processor) applyMetadata(path string, src os.FileInfo) error {
+	if p.PreserveOwner && os.Geteuid() == 0 {
+		if uid, gid, ok := fileOwner(src); ok {
+			if err := os.Lchown(path, uid, gid); err != nil {
+				return fmt.Errorf("error preserving ownership: %w", err)
+			}
+		}
+	}
+
+	// Temporary files are created 0600, so without --preserve-mode outputs
+	// get a fixed, world-readable 0644.
+	mode := os.FileMode(0o644)
+	if p.PreserveMode {
+		mode = src.Mode().Perm()
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		return fmt.Errorf("error setting permissions: %w", err)
+	}
+
+	if p.PreserveTimes {
+		if err := os.Chtimes(path, fileAccessTime(src), src.ModTime()); err != nil {
+			return fmt.Errorf("error preserving timestamps: %w", err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,16 @@
+//go:build !linux && !darwin
+
+package main
+
+import (
+	"os"
+	"time"
+)
+
+func fileOwner(info os.FileInfo) (int, int, bool) {
+	return 0, 0, false
+}
+
+func fileAccessTime(info os.FileInfo) time.Time {
+	return info.ModTime()
+}
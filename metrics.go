@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	metricsInterval  = 15 * time.Second
+	lastSuccessGauge = "avifconv_last_success_timestamp_seconds"
+)
+
+// encodeDurationBuckets are the upper bounds, in seconds, of the encode
+// duration histogram.
+var encodeDurationBuckets = []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300}
+
+// durationHistogram counts observations per bucket of encodeDurationBuckets.
+// Callers hold stats.mu.
+type durationHistogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+func (h *durationHistogram) observe(d time.Duration) {
+	if h.counts == nil {
+		h.counts = make([]uint64, len(encodeDurationBuckets))
+	}
+
+	s := d.Seconds()
+	for i, le := range encodeDurationBuckets {
+		if s <= le {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += s
+}
+
+// startMetrics serves /metrics on --metrics-addr and refreshes the
+// --metrics-file textfile while the batch runs. The returned function stops
+// both; the final textfile is written by writeMetricsFile.
+func (p *Processor) startMetrics(stats *ProcessStats, started time.Time) (func(), error) {
+	if p.MetricsAddr == "" && p.MetricsFile == "" {
+		return func() {}, nil
+	}
+
+	var server *http.Server
+	if p.MetricsAddr != "" {
+		ln, err := net.Listen("tcp", p.MetricsAddr)
+		if err != nil {
+			return nil, fmt.Errorf("error listening for metrics: %w", err)
+		}
+
+		mux := http.NewServeMux()
+		mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+			w.Write(p.renderMetrics(stats, started))
+		})
+		server = &http.Server{Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+
+		go server.Serve(ln)
+		p.Console.Info("Serving metrics on http://%s/metrics", ln.Addr())
+	}
+
+	done := make(chan struct{})
+	finished := make(chan struct{})
+
+	go func() {
+		defer close(finished)
+		if p.MetricsFile == "" {
+			<-done
+			return
+		}
+
+		ticker := time.NewTicker(metricsInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+
+			if err := p.writeMetricsFile(stats, started); err != nil {
+				p.Console.Warn("Metrics not written: %v", err)
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-finished
+
+		if server != nil {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			server.Shutdown(ctx)
+		}
+	}, nil
+}
+
+// writeMetricsFile writes the metrics atomically, as node_exporter's textfile
+// collector expects.
+func (p *Processor) writeMetricsFile(stats *ProcessStats, started time.Time) error {
+	if p.MetricsFile == "" {
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(p.MetricsFile), 0o755); err != nil {
+		return fmt.Errorf("error creating metrics directory: %w", err)
+	}
+	return writeFileAtomic(p.MetricsFile, p.renderMetrics(stats, started), 0o644)
+}
+
+// renderMetrics formats stats in the Prometheus text exposition format.
+func (p *Processor) renderMetrics(stats *ProcessStats, started time.Time) []byte {
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+
+	var b bytes.Buffer
+
+	metric := func(name, kind, help string, value float64) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n%s %s\n", name, help, name, kind, name, formatFloat(value))
+	}
+
+	metric("avifconv_files_processed_total", "counter", "Files converted, including reused duplicates.",
+		float64(stats.SuccessfulFiles+stats.DuplicateFiles))
+	metric("avifconv_files_failed_total", "counter", "Files that could not be converted.", float64(stats.FailedFiles))
+	metric("avifconv_files_skipped_total", "counter", "Files skipped as up to date.", float64(stats.SkippedFiles))
+	metric("avifconv_files_resumed_total", "counter", "Files skipped as already converted by the resumed batch.", float64(stats.ResumedFiles))
+	metric("avifconv_files_queued", "gauge", "Files queued for conversion in this run.", float64(stats.TotalFiles))
+	metric("avifconv_bytes_in_total", "counter", "Bytes of source images converted.", float64(stats.TotalOriginalSize))
+	metric("avifconv_bytes_out_total", "counter", "Bytes of AVIF output written.", float64(stats.TotalCompressedSize))
+
+	fmt.Fprintf(&b, "# HELP avifconv_failures_total Failed files by category.\n# TYPE avifconv_failures_total counter\n")
+	for _, category := range errorCategories {
+		fmt.Fprintf(&b, "avifconv_failures_total{category=%q} %d\n", category, stats.FailuresByCategory[category])
+	}
+
+	h := &stats.EncodeDurations
+	fmt.Fprintf(&b, "# HELP avifconv_encode_duration_seconds Time spent encoding each file.\n# TYPE avifconv_encode_duration_seconds histogram\n")
+	for i, le := range encodeDurationBuckets {
+		var n uint64
+		if h.counts != nil {
+			n = h.counts[i]
+		}
+		fmt.Fprintf(&b, "avifconv_encode_duration_seconds_bucket{le=%q} %d\n", formatFloat(le), n)
+	}
+	fmt.Fprintf(&b, "avifconv_encode_duration_seconds_bucket{le=\"+Inf\"} %d\n", h.count)
+	fmt.Fprintf(&b, "avifconv_encode_duration_seconds_sum %s\n", formatFloat(h.sum))
+	fmt.Fprintf(&b, "avifconv_encode_duration_seconds_count %d\n", h.count)
+
+	elapsed := stats.Elapsed
+	if elapsed == 0 {
+		elapsed = time.Since(started)
+	}
+	metric("avifconv_run_duration_seconds", "gauge", "Duration of the current or last run.", elapsed.Seconds())
+	metric("avifconv_run_start_timestamp_seconds", "gauge", "Start time of the current or last run.", unixSeconds(started))
+
+	// A run without conversions keeps the previous timestamp, so an alert on
+	// its age still fires when cron runs stop converting anything.
+	lastSuccess := unixSeconds(stats.LastSuccess)
+	if stats.LastSuccess.IsZero() {
+		lastSuccess = previousGauge(p.MetricsFile, lastSuccessGauge)
+	}
+	metric(lastSuccessGauge, "gauge", "Time of the last successful conversion.", lastSuccess)
+
+	return b.Bytes()
+}
+
+// previousGauge reads an unlabelled gauge from an earlier metrics file,
+// returning 0 when it is not there.
+func previousGauge(path, name string) float64 {
+	if path == "" {
+		return 0
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if value, ok := strings.CutPrefix(scanner.Text(), name+" "); ok {
+			if v, err := strconv.ParseFloat(value, 64); err == nil {
+				return v
+			}
+		}
+	}
+	return 0
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+func unixSeconds(t time.Time) float64 {
+	if t.IsZero() {
+		return 0
+	}
+	return float64(t.UnixMilli()) / 1000
+}
+
+// validateMetricsAddr rejects addresses net.Listen cannot parse before the
+// batch starts.
+func validateMetricsAddr(addr string) error {
+	if addr == "" {
+		return nil
+	}
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		return fmt.Errorf("error: metrics-addr must be host:port, e.g. 127.0.0.1:9470")
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+type JobOrder string
+
+const (
+	OrderWalk          JobOrder = "walk"
+	OrderLargestBytes  JobOrder = "largest"
+	OrderLargestPixels JobOrder = "largest-pixels"
+	OrderSmallest      JobOrder = "smallest"
+	OrderDirectory     JobOrder = "directory"
+)
+
+func parseJobOrder(s string) (JobOrder, error) {
+	switch o := JobOrder(strings.ToLower(s)); o {
+	case OrderWalk, OrderLargestBytes, OrderLargestPixels, OrderSmallest, OrderDirectory:
+		return o, nil
+	case "":
+		return OrderWalk, nil
+	}
+	return "", fmt.Errorf("error: unknown order %q (walk, largest, largest-pixels, smallest, directory)", s)
+}
+
+// orderJobs sorts files for the selected strategy. Starting the biggest jobs
+// first keeps a single large file from running alone at the end of a batch.
+// The sort is stable, so ties keep walk order.
+func (p *Processor) orderJobs(files []string) {
+	switch p.Order {
+	case OrderLargestBytes, OrderSmallest:
+		sizes := make(map[string]int64, len(files))
+		for _, f := range files {
+			if info, err := os.Stat(f); err == nil {
+				sizes[f] = info.Size()
+			}
+		}
+		if p.Order == OrderLargestBytes {
+			sort.SliceStable(files, func(i, j int) bool { return sizes[files[i]] > sizes[files[j]] })
+		} else {
+			sort.SliceStable(files, func(i, j int) bool { return sizes[files[i]] < sizes[files[j]] })
+		}
+	case OrderLargestPixels:
+		pixels := make(map[string]int64, len(files))
+		for _, f := range files {
+			pixels[f] = imagePixels(f)
+		}
+		sort.SliceStable(files, func(i, j int) bool { return pixels[files[i]] > pixels[files[j]] })
+	case OrderDirectory:
+		sort.SliceStable(files, func(i, j int) bool { return filepath.Dir(files[i]) < filepath.Dir(files[j]) })
+	}
+}
+
+// imagePixels returns the pixel count from the image header, or 0 if the
+// header cannot be read.
+func imagePixels(path string) int64 {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+
+	cfg, _, err := image.DecodeConfig(f)
+	if err != nil {
+		return 0
+	}
+	return int64(cfg.Width) * int64(cfg.Height)
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gen2brain/avif"
+)
+
+type ReportFormat string
+
+const (
+	ReportJSON   ReportFormat = "json"
+	ReportNDJSON ReportFormat = "ndjson"
+)
+
+// parseReportFormat resolves --report-format, guessing from the report's
+// extension when it is not given.
+func parseReportFormat(s, path string) (ReportFormat, error) {
+	switch f := ReportFormat(strings.ToLower(s)); f {
+	case ReportJSON, ReportNDJSON:
+		return f, nil
+	case "":
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".ndjson", ".jsonl":
+			return ReportNDJSON, nil
+		}
+		return ReportJSON, nil
+	}
+	return "", fmt.Errorf("error: unknown report format %q (json, ndjson)", s)
+}
+
+const (
+	StatusConverted = "converted"
+	StatusFailed    = "failed"
+	StatusSkipped   = "skipped"
+	StatusResumed   = "resumed"
+	StatusDuplicate = "duplicate"
+)
+
+type reportOptions struct {
+	Quality           int    `json:"quality"`
+	QualityAlpha      int    `json:"quality_alpha"`
+	Speed             int    `json:"speed"`
+	ChromaSubsampling string `json:"chroma_subsampling"`
+}
+
+func newReportOptions(o avif.Options) reportOptions {
+	return reportOptions{
+		Quality:           o.Quality,
+		QualityAlpha:      o.QualityAlpha,
+		Speed:             o.Speed,
+		ChromaSubsampling: strings.TrimPrefix(o.ChromaSubsampling.String(), "YCbCrSubsampleRatio"),
+	}
+}
+
+// FileRecord describes what happened to one source file. processFileWithStats
+// fills in the measurements; the worker adds the outcome.
+type FileRecord struct {
+	Type           string        `json:"type"`
+	Source         string        `json:"source"`
+	Output         string        `json:"output,omitempty"`
+	Status         string        `json:"status"`
+	Error          string        `json:"error,omitempty"`
+	Category       ErrorCategory `json:"category,omitempty"`
+	OriginalSize   int64         `json:"original_size"`
+	CompressedSize int64         `json:"compressed_size"`
+	Width          int           `json:"width,omitempty"`
+	Height         int           `json:"height,omitempty"`
+	DecodeMS       float64       `json:"decode_ms"`
+	EncodeMS       float64       `json:"encode_ms"`
+	PSNR           float64       `json:"psnr,omitempty"`
+	Worker         int           `json:"worker,omitempty"`
+	Options        reportOptions `json:"options"`
+
+	SourceThumb template.URL `json:"-"`
+	OutputThumb template.URL `json:"-"`
+}
+
+func (r *FileRecord) encodeDuration() time.Duration {
+	return time.Duration(r.EncodeMS * float64(time.Millisecond))
+}
+
+// sizeRatio is the output size as a fraction of the source, 0 if unknown.
+func (r *FileRecord) sizeRatio() float64 {
+	if r.OriginalSize <= 0 {
+		return 0
+	}
+	return float64(r.CompressedSize) / float64(r.OriginalSize)
+}
+
+// setResult records the outcome of a conversion attempt.
+func (r *FileRecord) setResult(origSize, compSize int64, err error) {
+	r.OriginalSize = origSize
+	r.CompressedSize = compSize
+	if err != nil {
+		r.Status = StatusFailed
+		r.Error = err.Error()
+		r.Category = classifyError(err)
+		r.CompressedSize = 0
+		return
+	}
+	if r.Status == "" {
+		r.Status = StatusConverted
+	}
+}
+
+type ReportSummary struct {
+	Type               string                `json:"type"`
+	Root               string                `json:"root"`
+	Started            time.Time             `json:"started"`
+	ElapsedMS          float64               `json:"elapsed_ms"`
+	TotalFiles         int                   `json:"total_files"`
+	Converted          int                   `json:"converted"`
+	Failed             int                   `json:"failed"`
+	Skipped            int                   `json:"skipped"`
+	Resumed            int                   `json:"resumed"`
+	Duplicates         int                   `json:"duplicates"`
+	FailuresByCategory map[ErrorCategory]int `json:"failures_by_category,omitempty"`
+	OriginalSize       int64                 `json:"original_size"`
+	CompressedSize     int64                 `json:"compressed_size"`
+	CompressionRatio   float64               `json:"compression_ratio"`
+	Options            reportOptions         `json:"options"`
+	Aborted            bool                  `json:"aborted,omitempty"`
+	Interrupted        bool                  `json:"interrupted,omitempty"`
+}
+
+// Report collects a FileRecord per source. NDJSON reports are written as
+// records arrive, so a consumer can follow a running batch; JSON reports are
+// written atomically once the summary is known.
+type Report struct {
+	Path     string
+	Format   ReportFormat
+	HTMLPath string
+	Root     string
+
+	mu      sync.Mutex
+	file    *os.File
+	records []*FileRecord
+
+	// bySaving and byPSNR are the converted records that keep thumbnails.
+	bySaving []*FileRecord
+	byPSNR   []*FileRecord
+}
+
+func NewReport(path string, format ReportFormat, htmlPath, root string) *Report {
+	return &Report{Path: path, Format: format, HTMLPath: htmlPath, Root: root}
+}
+
+// WantsComparison reports whether records need scores and thumbnails.
+func (r *Report) WantsComparison() bool {
+	return r != nil && r.HTMLPath != ""
+}
+
+func (r *Report) Add(rec *FileRecord) error {
+	if r == nil {
+		return nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	rec.Type = "file"
+	r.records = append(r.records, rec)
+	r.rankThumbnails(rec)
+
+	if r.Path == "" || r.Format != ReportNDJSON {
+		return nil
+	}
+	return r.writeLine(rec)
+}
+
+// Records returns the records added so far.
+func (r *Report) Records() []*FileRecord {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]*FileRecord(nil), r.records...)
+}
+
+func (r *Report) writeLine(v any) error {
+	if r.file == nil {
+		if err := os.MkdirAll(filepath.Dir(r.Path), 0o755); err != nil {
+			return fmt.Errorf("error creating report directory: %w", err)
+		}
+		f, err := os.Create(r.Path)
+		if err != nil {
+			return fmt.Errorf("error creating report: %w", err)
+		}
+		r.file = f
+	}
+
+	line, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("error encoding report record: %w", err)
+	}
+	if _, err := r.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("error writing report: %w", err)
+	}
+	return nil
+}
+
+// Finish appends the summary, closes the report and renders the HTML report.
+func (r *Report) Finish(summary ReportSummary) error {
+	if r == nil {
+		return nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	summary.Type = "summary"
+
+	if r.Path != "" {
+		if err := r.finishData(summary); err != nil {
+			return err
+		}
+	}
+	if r.HTMLPath != "" {
+		return writeHTMLReport(r.HTMLPath, r.Root, r.records, summary)
+	}
+	return nil
+}
+
+func (r *Report) finishData(summary ReportSummary) error {
+	if r.Format == ReportNDJSON {
+		if err := r.writeLine(summary); err != nil {
+			return err
+		}
+		err := r.file.Close()
+		r.file = nil
+		return err
+	}
+
+	records := r.records
+	if records == nil {
+		records = []*FileRecord{}
+	}
+	data, err := json.MarshalIndent(struct {
+		Files   []*FileRecord `json:"files"`
+		Summary ReportSummary `json:"summary"`
+	}{records, summary}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding report: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(r.Path), 0o755); err != nil {
+		return fmt.Errorf("error creating report directory: %w", err)
+	}
+	return writeFileAtomic(r.Path, append(data, '\n'), 0o644)
+}
+
+// newFileRecord starts a record for filePath with the options of this run.
+func (p *Processor) newFileRecord(filePath string, worker int) *FileRecord {
+	return &FileRecord{
+		Source:  filePath,
+		Worker:  worker,
+		Options: newReportOptions(p.Options),
+	}
+}
+
+// addRecord hands a finished record to the report, if one was requested.
+func (p *Processor) addRecord(rec *FileRecord) {
+	if err := p.Report.Add(rec); err != nil {
+		p.Console.Warn("Report not updated: %v", err)
+	}
+}
+
+// finishReport writes the summary record for the batch.
+func (p *Processor) finishReport(stats *ProcessStats, started time.Time, interrupted bool) error {
+	if p.Report == nil {
+		return nil
+	}
+
+	var ratio float64
+	if stats.TotalOriginalSize > 0 {
+		ratio = float64(stats.TotalCompressedSize) / float64(stats.TotalOriginalSize) * 100
+	}
+
+	return p.Report.Finish(ReportSummary{
+		Root:               p.Root,
+		Started:            started,
+		ElapsedMS:          millis(stats.Elapsed),
+		TotalFiles:         stats.TotalFiles,
+		Converted:          stats.SuccessfulFiles,
+		Failed:             stats.FailedFiles,
+		Skipped:            stats.SkippedFiles,
+		Resumed:            stats.ResumedFiles,
+		Duplicates:         stats.DuplicateFiles,
+		FailuresByCategory: stats.FailuresByCategory,
+		OriginalSize:       stats.TotalOriginalSize,
+		CompressedSize:     stats.TotalCompressedSize,
+		CompressionRatio:   ratio,
+		Options:            newReportOptions(p.Options),
+		Aborted:            stats.Aborted,
+		Interrupted:        interrupted,
+	})
+}
+
+func millis(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
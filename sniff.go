@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+)
+
+// sniffLen is enough to see the brands of a typical ISO-BMFF ftyp box.
+const sniffLen = 64
+
+var decodableFormats = map[string]bool{
+	"jpeg": true,
+	"png":  true,
+	"webp": true,
+}
+
+// sniffFormat identifies an image by its leading bytes and returns a short
+// format name, or "" if the content is not recognised.
+func sniffFormat(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	buf := make([]byte, sniffLen)
+	n, err := io.ReadFull(f, buf)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", err
+	}
+
+	return detectFormat(buf[:n]), nil
+}
+
+func detectFormat(b []byte) string {
+	switch {
+	case bytes.HasPrefix(b, []byte{0xFF, 0xD8, 0xFF}):
+		return "jpeg"
+	case bytes.HasPrefix(b, []byte("\x89PNG\r\n\x1a\n")):
+		return "png"
+	case len(b) >= 12 && bytes.Equal(b[:4], []byte("RIFF")) && bytes.Equal(b[8:12], []byte("WEBP")):
+		return "webp"
+	case bytes.HasPrefix(b, []byte("GIF87a")), bytes.HasPrefix(b, []byte("GIF89a")):
+		return "gif"
+	case bytes.HasPrefix(b, []byte("BM")):
+		return "bmp"
+	case bytes.HasPrefix(b, []byte("II*\x00")), bytes.HasPrefix(b, []byte("MM\x00*")):
+		return "tiff"
+	case len(b) >= 12 && bytes.Equal(b[4:8], []byte("ftyp")):
+		return detectHEIF(b)
+	}
+	return ""
+}
+
+// detectHEIF classifies an ISO-BMFF file by the major and compatible brands
+// of its ftyp box. Many AVIF files carry the generic HEIF brand mif1 as their
+// major brand, so any avif or avis brand wins; only HEVC brands mean HEIC.
+func detectHEIF(b []byte) string {
+	end := len(b)
+	if size := int(binary.BigEndian.Uint32(b[:4])); size >= 12 && size < end {
+		end = size
+	}
+
+	brands := []string{string(b[8:12])}
+	for i := 16; i+4 <= end; i += 4 {
+		brands = append(brands, string(b[i:i+4]))
+	}
+
+	format := ""
+	for _, brand := range brands {
+		switch brand {
+		case "avif", "avis":
+			return "avif"
+		case "heic", "heix", "hevc", "hevx", "heim", "heis":
+			format = "heic"
+		case "mif1", "msf1":
+			if format == "" {
+				format = "heif"
+			}
+		}
+	}
+	return format
+}
+
+// skippableFormats are recognised images that are left alone with a
+// warning instead of counting as failures.
+var skippableFormats = map[string]bool{
+	"avif": true,
+	"heic": true,
+	"heif": true,
+}
+
+// checkCandidate sniffs path and decides whether it can be converted. The
+// returned note explains a mislabeled or skipped file and is empty when the
+// content matches the extension. A file with an image extension that cannot
+// be read or converted returns an error, so it is counted as a failure.
+func checkCandidate(path, extFormat string) (ok bool, note string, err error) {
+	format, err := sniffFormat(path)
+	if err != nil {
+		return false, "", fmt.Errorf("error reading file: %w", err)
+	}
+
+	switch {
+	case format == "avif":
+		return false, "content is already AVIF", nil
+	case skippableFormats[format]:
+		return false, fmt.Sprintf("content is %s, which is not supported", format), nil
+	case extFormat == "" && !decodableFormats[format]:
+		return false, "unrecognised image content", nil
+	case format == "":
+		return false, "", categorized(CategoryDecode, fmt.Errorf("unrecognised image content"))
+	case !decodableFormats[format]:
+		return false, "", categorized(CategoryDecode, fmt.Errorf("content is %s, which is not supported", format))
+	case extFormat == "":
+		return true, fmt.Sprintf("extensionless file is %s", format), nil
+	case format != extFormat:
+		return true, fmt.Sprintf("labeled %s but content is %s", extFormat, format), nil
+	}
+
+	return true, "", nil
+}
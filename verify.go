@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"os"
+
+	"github.com/gen2brain/avif"
+)
+
+type VerificationError struct {
+	Reason string
+}
+
+func (e *VerificationError) Error() string {
+	return "verification failed: " + e.Reason
+}
+
+// verifyOutput checks the AVIF at avifPath against src and returns the
+// decoded output, so callers that also need it do not decode it again.
+func verifyOutput(avifPath string, src image.Image, minPSNR float64) (image.Image, error) {
+	f, err := os.Open(avifPath)
+	if err != nil {
+		return nil, &VerificationError{Reason: fmt.Sprintf("cannot open output: %v", err)}
+	}
+	defer f.Close()
+
+	cfg, err := avif.DecodeConfig(f)
+	if err != nil {
+		return nil, &VerificationError{Reason: fmt.Sprintf("cannot read output header: %v", err)}
+	}
+
+	srcBounds := src.Bounds()
+	if cfg.Width != srcBounds.Dx() || cfg.Height != srcBounds.Dy() {
+		return nil, &VerificationError{Reason: fmt.Sprintf("dimension mismatch: source %dx%d, output %dx%d",
+			srcBounds.Dx(), srcBounds.Dy(), cfg.Width, cfg.Height)}
+	}
+
+	if _, err := f.Seek(0, 0); err != nil {
+		return nil, &VerificationError{Reason: fmt.Sprintf("cannot rewind output: %v", err)}
+	}
+
+	out, err := avif.Decode(f)
+	if err != nil {
+		return nil, &VerificationError{Reason: fmt.Sprintf("cannot decode output: %v", err)}
+	}
+
+	outBounds := out.Bounds()
+	if outBounds.Dx() != srcBounds.Dx() || outBounds.Dy() != srcBounds.Dy() {
+		return nil, &VerificationError{Reason: fmt.Sprintf("decoded dimension mismatch: source %dx%d, output %dx%d",
+			srcBounds.Dx(), srcBounds.Dy(), outBounds.Dx(), outBounds.Dy())}
+	}
+
+	srcAlpha, outAlpha := hasAlpha(src), hasAlpha(out)
+	if srcAlpha != outAlpha {
+		return nil, &VerificationError{Reason: fmt.Sprintf("alpha mismatch: source alpha=%t, output alpha=%t",
+			srcAlpha, outAlpha)}
+	}
+
+	if minPSNR > 0 {
+		psnr := computePSNR(src, out)
+		if psnr < minPSNR {
+			return nil, &VerificationError{Reason: fmt.Sprintf("PSNR %.2f dB below threshold %.2f dB", psnr, minPSNR)}
+		}
+	}
+
+	return out, nil
+}
+
+func hasAlpha(img image.Image) bool {
+	if o, ok := img.(interface{ Opaque() bool }); ok {
+		return !o.Opaque()
+	}
+
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if _, _, _, a := img.At(x, y).RGBA(); a != 0xffff {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// computePSNR compares the two images over their RGB channels at 8-bit depth.
+// Identical images return +Inf.
+func computePSNR(a, b image.Image) float64 {
+	ab, bb := a.Bounds(), b.Bounds()
+
+	var sum float64
+	var n int64
+	for y := 0; y < ab.Dy(); y++ {
+		for x := 0; x < ab.Dx(); x++ {
+			r1, g1, b1, _ := a.At(ab.Min.X+x, ab.Min.Y+y).RGBA()
+			r2, g2, b2, _ := b.At(bb.Min.X+x, bb.Min.Y+y).RGBA()
+
+			dr := float64(r1>>8) - float64(r2>>8)
+			dg := float64(g1>>8) - float64(g2>>8)
+			db := float64(b1>>8) - float64(b2>>8)
+			sum += dr*dr + dg*dg + db*db
+			n += 3
+		}
+	}
+
+	if n == 0 || sum == 0 {
+		return math.Inf(1)
+	}
+
+	mse := sum / float64(n)
+	return 10 * math.Log10(255*255/mse)
+}
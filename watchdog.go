@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/gen2brain/avif"
+)
+
+// encodeWorkerCommand is the hidden subcommand used to run one encode in a
+// child process that can be killed when it exceeds the per-file timeout.
+const encodeWorkerCommand = "__encode"
+
+const watchdogInterval = time.Second
+
+type TimeoutError struct {
+	Timeout time.Duration
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("timed out after %s", e.Timeout)
+}
+
+// encodeInSubprocess encodes filePath in a child process and streams the AVIF
+// to w. The child is killed once p.Timeout has passed.
+func (p *Processor) encodeInSubprocess(ctx context.Context, filePath string, w io.Writer) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("error locating executable: %w", err)
+	}
+
+	opts, err := json.Marshal(p.Options)
+	if err != nil {
+		return fmt.Errorf("error encoding options: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, p.Timeout)
+	defer cancel()
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, exe, encodeWorkerCommand, string(opts), filePath)
+	cmd.Stdout = w
+	cmd.Stderr = &stderr
+
+	err = cmd.Run()
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return &TimeoutError{Timeout: p.Timeout}
+	}
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return categorized(CategoryEncode, fmt.Errorf("error encoding to AVIF: %s", msg))
+		}
+		return categorized(CategoryEncode, fmt.Errorf("error encoding to AVIF: %w", err))
+	}
+
+	return nil
+}
+
+// RunEncodeWorker is the child side of encodeInSubprocess: it decodes the
+// source and writes the AVIF to stdout.
+func RunEncodeWorker(args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("usage: %s <options json> <file>", encodeWorkerCommand)
+	}
+
+	// A Ctrl-C in the terminal reaches the whole process group; the parent
+	// lets started files finish and kills the child itself on timeout.
+	signal.Ignore(os.Interrupt)
+
+	var opts avif.Options
+	if err := json.Unmarshal([]byte(args[0]), &opts); err != nil {
+		return fmt.Errorf("invalid options: %w", err)
+	}
+
+	f, err := os.Open(args[1])
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return fmt.Errorf("error decoding image: %w", err)
+	}
+
+	return avif.Encode(os.Stdout, img, opts)
+}
+
+// startWatchdog warns once per file when a worker has spent longer than
+// p.SlowWarning on it. It stops when the returned function is called.
+func (p *Processor) startWatchdog(statuses []workerStatus, stats *ProcessStats) func() {
+	if p.SlowWarning <= 0 {
+		return func() {}
+	}
+
+	done := make(chan struct{})
+	finished := make(chan struct{})
+
+	go func() {
+		defer close(finished)
+
+		ticker := time.NewTicker(watchdogInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+
+			type slowFile struct {
+				worker int
+				file   string
+				took   time.Duration
+			}
+
+			var slow []slowFile
+			stats.mu.Lock()
+			for i := range statuses {
+				st := &statuses[i]
+				if !st.Busy || st.Warned {
+					continue
+				}
+				if took := time.Since(st.StartTime); took > p.SlowWarning {
+					st.Warned = true
+					slow = append(slow, slowFile{i + 1, st.CurrentFile, took.Round(time.Second)})
+				}
+			}
+			stats.mu.Unlock()
+
+			for _, f := range slow {
+				p.Console.Warnw("File is taking longer than expected", "worker", f.worker, "file", f.file, "duration", f.took)
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-finished
+	}
+}